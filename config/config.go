@@ -0,0 +1,462 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime options of the API.
+type Config struct {
+	// Port is the TCP port the server listens on.
+	Port int
+
+	// DefaultLimit is the page size of list requests that do not pass
+	// limit; MaxLimit caps the page size a client can ask for.
+	DefaultLimit int
+	MaxLimit     int
+
+	// Envelope names the keys of the paginated list response.
+	Envelope EnvelopeKeys
+
+	// CanonicalHost, when set, is the only Host header the API answers to.
+	CanonicalHost string
+	// CanonicalHostPolicy decides how requests for another host are
+	// handled: HostPolicyReject or HostPolicyRedirect.
+	CanonicalHostPolicy string
+
+	// ResponseMeta adds a meta object with the request ID and server time
+	// to every JSON response.
+	ResponseMeta bool
+
+	// LogBodies logs request and response bodies of the book routes. It is
+	// meant for debugging only.
+	LogBodies bool
+	// LogBodiesMaxBytes caps how much of each body is logged.
+	LogBodiesMaxBytes int
+	// LogRedactFields lists JSON fields whose values are masked in logged
+	// bodies.
+	LogRedactFields []string
+	// ResponseRedactFields lists JSON fields removed from response bodies,
+	// such as id, for clients that do not send the API key.
+	ResponseRedactFields []string
+
+	// StrictQuery rejects requests with query parameters the endpoint does
+	// not know instead of ignoring them.
+	StrictQuery bool
+
+	// GenerateMissingTitles lets books without a title through validation
+	// by giving them a placeholder built from the author and year.
+	GenerateMissingTitles bool
+
+	// EmptyListNoContent answers an empty page of the book list with 204
+	// instead of 200 and an empty data array.
+	EmptyListNoContent bool
+
+	// ShutdownDrainDelay is how long new requests are refused with 503
+	// after a shutdown signal before the listener is closed.
+	ShutdownDrainDelay time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to
+	// finish once the listener is closed.
+	ShutdownTimeout time.Duration
+
+	// SoftDeleteRetention is how long deleted books stay in the trash
+	// before they are purged for good, checked every
+	// SoftDeletePurgeInterval. Zero keeps them until purged by hand.
+	SoftDeleteRetention     time.Duration
+	SoftDeletePurgeInterval time.Duration
+
+	// SeqBase is the catalog number given to the first book created.
+	SeqBase int64
+
+	// RelatedMaxDepth bounds how many hops the related books endpoint may
+	// be asked to follow.
+	RelatedMaxDepth int
+
+	// BookCacheSize is how many marshaled books the single book endpoint
+	// keeps in its LRU cache. Zero disables the cache.
+	BookCacheSize int
+
+	// CacheMaxAge is how long clients may reuse a fetched book before
+	// revalidating it with its ETag. Zero makes them revalidate every time.
+	CacheMaxAge time.Duration
+
+	// UniqueTitlePerAuthor rejects writes that would give an author two
+	// books with the same title.
+	UniqueTitlePerAuthor bool
+
+	// DisabledMethods lists the HTTP methods the book routes refuse with
+	// 405, e.g. every write method for a read-only mirror.
+	DisabledMethods []string
+
+	// ImportURLHosts lists the hosts books may be imported from by URL.
+	// Empty refuses every URL. ImportURLTimeout bounds each fetch.
+	ImportURLHosts   []string
+	ImportURLTimeout time.Duration
+	// ImportWorkers is how many goroutines validate the rows of an import
+	// or bulk create.
+	ImportWorkers int
+	// EventsMaxClients is how many clients may be connected to the
+	// /api/books/events WebSocket at once.
+	EventsMaxClients int
+	// CursorSecret signs the cursors of the book list. Empty picks a
+	// random one at startup, so cursors do not survive a restart or work
+	// across replicas.
+	CursorSecret string
+
+	// BooksDBPath is the JSON file the store is loaded from and saved to.
+	// Empty keeps the store in memory only.
+	BooksDBPath string
+	// SeedData fills an empty store with a few sample books at startup. It
+	// is meant for demos and local development.
+	SeedData bool
+	// APIKey, when set, must be sent in the X-API-Key header of every
+	// write to the book routes. Reads never need it.
+	APIKey string
+
+	// RateLimitMax is how many requests one client IP may make per
+	// RateLimitWindow. Zero turns rate limiting off.
+	RateLimitMax    int
+	RateLimitWindow time.Duration
+
+	// BodyLimit is the largest request body, in bytes, the server accepts.
+	// Larger requests are refused with 413 before reaching a handler.
+	BodyLimit int
+
+	// CORSOrigins lists the origins browsers may call the API from, or "*"
+	// for any origin.
+	CORSOrigins []string
+
+	// InvalidRecords decides what happens to loaded books that fail
+	// validation: InvalidRecordsKeep, InvalidRecordsQuarantine or
+	// InvalidRecordsFail. They are logged in every case.
+	InvalidRecords string
+
+	// CompressLevel sets how hard responses are compressed for clients
+	// that accept it: CompressOff, CompressSpeed, CompressDefault or
+	// CompressBest.
+	CompressLevel string
+}
+
+const (
+	HostPolicyReject   = "reject"
+	HostPolicyRedirect = "redirect"
+)
+
+const (
+	InvalidRecordsKeep       = "keep"
+	InvalidRecordsQuarantine = "quarantine"
+	InvalidRecordsFail       = "fail"
+)
+
+const (
+	CompressOff     = "off"
+	CompressSpeed   = "speed"
+	CompressDefault = "default"
+	CompressBest    = "best"
+)
+
+// EnvelopeKeys maps the fields of the paginated list response to the JSON
+// keys they are serialized under.
+type EnvelopeKeys struct {
+	Data       string
+	Page       string
+	Limit      string
+	Total      string
+	TotalPages string
+	NextCursor string
+}
+
+func Default() Config {
+	return Config{
+		Port:         3000,
+		DefaultLimit: 50,
+		MaxLimit:     200,
+		Envelope: EnvelopeKeys{
+			Data:       "data",
+			Page:       "page",
+			Limit:      "limit",
+			Total:      "total",
+			TotalPages: "total_pages",
+			NextCursor: "next_cursor",
+		},
+		CanonicalHostPolicy:     HostPolicyReject,
+		LogBodiesMaxBytes:       2048,
+		ShutdownDrainDelay:      5 * time.Second,
+		ShutdownTimeout:         10 * time.Second,
+		SoftDeletePurgeInterval: time.Hour,
+		SeqBase:                 1,
+		RelatedMaxDepth:         2,
+		BooksDBPath:             "books.json",
+		InvalidRecords:          InvalidRecordsKeep,
+		CompressLevel:           CompressDefault,
+		BodyLimit:               1 << 20,
+		ImportURLTimeout:        10 * time.Second,
+		ImportWorkers:           runtime.NumCPU(),
+		EventsMaxClients:        100,
+		RateLimitMax:            100,
+		RateLimitWindow:         time.Minute,
+		CORSOrigins:             []string{"*"},
+	}
+}
+
+// Load returns the default configuration overridden by any options set in
+// the environment.
+func Load() (Config, error) {
+	cfg := Default()
+	if err := envInt(&cfg.Port, "PORT"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.DefaultLimit, "DEFAULT_LIMIT"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.MaxLimit, "MAX_LIMIT"); err != nil {
+		return cfg, err
+	}
+	envString(&cfg.Envelope.Data, "ENVELOPE_DATA_KEY")
+	envString(&cfg.Envelope.Page, "ENVELOPE_PAGE_KEY")
+	envString(&cfg.Envelope.Limit, "ENVELOPE_LIMIT_KEY")
+	envString(&cfg.Envelope.Total, "ENVELOPE_TOTAL_KEY")
+	envString(&cfg.Envelope.TotalPages, "ENVELOPE_TOTAL_PAGES_KEY")
+	envString(&cfg.Envelope.NextCursor, "ENVELOPE_NEXT_CURSOR_KEY")
+	envString(&cfg.CanonicalHost, "CANONICAL_HOST")
+	envString(&cfg.CanonicalHostPolicy, "CANONICAL_HOST_POLICY")
+	if err := envBool(&cfg.ResponseMeta, "RESPONSE_META"); err != nil {
+		return cfg, err
+	}
+	if err := envBool(&cfg.LogBodies, "LOG_BODIES"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.LogBodiesMaxBytes, "LOG_BODIES_MAX_BYTES"); err != nil {
+		return cfg, err
+	}
+	envList(&cfg.LogRedactFields, "LOG_REDACT_FIELDS")
+	envList(&cfg.ResponseRedactFields, "RESPONSE_REDACT_FIELDS")
+	if err := envBool(&cfg.StrictQuery, "STRICT_QUERY"); err != nil {
+		return cfg, err
+	}
+	if err := envBool(&cfg.GenerateMissingTitles, "GENERATE_MISSING_TITLES"); err != nil {
+		return cfg, err
+	}
+	if err := envBool(&cfg.EmptyListNoContent, "EMPTY_LIST_NO_CONTENT"); err != nil {
+		return cfg, err
+	}
+	if err := envInt64(&cfg.SeqBase, "SEQ_BASE"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.RelatedMaxDepth, "RELATED_MAX_DEPTH"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.BookCacheSize, "BOOK_CACHE_SIZE"); err != nil {
+		return cfg, err
+	}
+	if err := envBool(&cfg.UniqueTitlePerAuthor, "UNIQUE_TITLE_PER_AUTHOR"); err != nil {
+		return cfg, err
+	}
+	envList(&cfg.DisabledMethods, "DISABLED_METHODS")
+	// Unlike the other options, an empty value is meaningful here: it turns
+	// persistence off.
+	if v, ok := os.LookupEnv("BOOKS_DB_PATH"); ok {
+		cfg.BooksDBPath = v
+	}
+	envList(&cfg.ImportURLHosts, "IMPORT_URL_HOSTS")
+	if err := envDuration(&cfg.ImportURLTimeout, "IMPORT_URL_TIMEOUT"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.ImportWorkers, "IMPORT_WORKERS"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.EventsMaxClients, "EVENTS_MAX_CLIENTS"); err != nil {
+		return cfg, err
+	}
+	envString(&cfg.InvalidRecords, "INVALID_RECORDS")
+	envString(&cfg.CursorSecret, "CURSOR_SECRET")
+	if err := envBool(&cfg.SeedData, "SEED_DATA"); err != nil {
+		return cfg, err
+	}
+	envString(&cfg.CompressLevel, "COMPRESS_LEVEL")
+	envString(&cfg.APIKey, "API_KEY")
+	envList(&cfg.CORSOrigins, "CORS_ORIGINS")
+	if err := envInt(&cfg.BodyLimit, "BODY_LIMIT"); err != nil {
+		return cfg, err
+	}
+	if err := envInt(&cfg.RateLimitMax, "RATE_LIMIT_MAX"); err != nil {
+		return cfg, err
+	}
+	if err := envDuration(&cfg.RateLimitWindow, "RATE_LIMIT_WINDOW"); err != nil {
+		return cfg, err
+	}
+	if err := envDuration(&cfg.ShutdownDrainDelay, "SHUTDOWN_DRAIN_DELAY"); err != nil {
+		return cfg, err
+	}
+	if err := envDuration(&cfg.ShutdownTimeout, "SHUTDOWN_TIMEOUT"); err != nil {
+		return cfg, err
+	}
+	if err := envDuration(&cfg.CacheMaxAge, "CACHE_MAX_AGE"); err != nil {
+		return cfg, err
+	}
+	if err := envDuration(&cfg.SoftDeleteRetention, "SOFT_DELETE_RETENTION"); err != nil {
+		return cfg, err
+	}
+	if err := envDuration(&cfg.SoftDeletePurgeInterval, "SOFT_DELETE_PURGE_INTERVAL"); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return cfg, fmt.Errorf("PORT must be between 1 and 65535, got %d", cfg.Port)
+	}
+	if cfg.MaxLimit < 1 {
+		return cfg, fmt.Errorf("MAX_LIMIT must be positive, got %d", cfg.MaxLimit)
+	}
+	if cfg.DefaultLimit < 1 || cfg.DefaultLimit > cfg.MaxLimit {
+		return cfg, fmt.Errorf("DEFAULT_LIMIT must be between 1 and MAX_LIMIT (%d), got %d", cfg.MaxLimit, cfg.DefaultLimit)
+	}
+	switch cfg.CanonicalHostPolicy {
+	case HostPolicyReject, HostPolicyRedirect:
+	default:
+		return cfg, fmt.Errorf("CANONICAL_HOST_POLICY must be %q or %q, got %q",
+			HostPolicyReject, HostPolicyRedirect, cfg.CanonicalHostPolicy)
+	}
+	switch cfg.InvalidRecords {
+	case InvalidRecordsKeep, InvalidRecordsQuarantine, InvalidRecordsFail:
+	default:
+		return cfg, fmt.Errorf("INVALID_RECORDS must be %q, %q or %q, got %q",
+			InvalidRecordsKeep, InvalidRecordsQuarantine, InvalidRecordsFail, cfg.InvalidRecords)
+	}
+	switch cfg.CompressLevel {
+	case CompressOff, CompressSpeed, CompressDefault, CompressBest:
+	default:
+		return cfg, fmt.Errorf("COMPRESS_LEVEL must be %q, %q, %q or %q, got %q",
+			CompressOff, CompressSpeed, CompressDefault, CompressBest, cfg.CompressLevel)
+	}
+	if cfg.ShutdownDrainDelay < 0 || cfg.ShutdownTimeout < 0 {
+		return cfg, fmt.Errorf("SHUTDOWN_DRAIN_DELAY and SHUTDOWN_TIMEOUT must not be negative")
+	}
+	if cfg.ImportURLTimeout <= 0 {
+		return cfg, fmt.Errorf("IMPORT_URL_TIMEOUT must be positive, got %s", cfg.ImportURLTimeout)
+	}
+	if cfg.ImportWorkers < 1 {
+		return cfg, fmt.Errorf("IMPORT_WORKERS must be positive, got %d", cfg.ImportWorkers)
+	}
+	if cfg.EventsMaxClients < 1 {
+		return cfg, fmt.Errorf("EVENTS_MAX_CLIENTS must be positive, got %d", cfg.EventsMaxClients)
+	}
+	if cfg.CacheMaxAge < 0 {
+		return cfg, fmt.Errorf("CACHE_MAX_AGE must not be negative, got %s", cfg.CacheMaxAge)
+	}
+	if cfg.SoftDeleteRetention < 0 {
+		return cfg, fmt.Errorf("SOFT_DELETE_RETENTION must not be negative, got %s", cfg.SoftDeleteRetention)
+	}
+	if cfg.SoftDeletePurgeInterval <= 0 {
+		return cfg, fmt.Errorf("SOFT_DELETE_PURGE_INTERVAL must be positive, got %s", cfg.SoftDeletePurgeInterval)
+	}
+	if cfg.SeqBase < 0 {
+		return cfg, fmt.Errorf("SEQ_BASE must not be negative, got %d", cfg.SeqBase)
+	}
+	for i, m := range cfg.DisabledMethods {
+		m = strings.ToUpper(m)
+		switch m {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete, http.MethodOptions:
+			cfg.DisabledMethods[i] = m
+		default:
+			return cfg, fmt.Errorf("DISABLED_METHODS contains unknown method %q", m)
+		}
+	}
+	if cfg.RelatedMaxDepth < 1 {
+		return cfg, fmt.Errorf("RELATED_MAX_DEPTH must be positive, got %d", cfg.RelatedMaxDepth)
+	}
+	if cfg.BookCacheSize < 0 {
+		return cfg, fmt.Errorf("BOOK_CACHE_SIZE must not be negative, got %d", cfg.BookCacheSize)
+	}
+	if cfg.RateLimitMax < 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_MAX must not be negative, got %d", cfg.RateLimitMax)
+	}
+	if cfg.RateLimitWindow <= 0 {
+		return cfg, fmt.Errorf("RATE_LIMIT_WINDOW must be positive, got %s", cfg.RateLimitWindow)
+	}
+	if cfg.BodyLimit < 1 {
+		return cfg, fmt.Errorf("BODY_LIMIT must be positive, got %d", cfg.BodyLimit)
+	}
+	if cfg.LogBodiesMaxBytes < 1 {
+		return cfg, fmt.Errorf("LOG_BODIES_MAX_BYTES must be positive, got %d", cfg.LogBodiesMaxBytes)
+	}
+	return cfg, nil
+}
+
+func envString(dst *string, key string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+func envBool(dst *bool, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s must be a boolean, got %q", key, v)
+	}
+	*dst = b
+	return nil
+}
+
+func envInt(dst *int, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got %q", key, v)
+	}
+	*dst = n
+	return nil
+}
+
+func envInt64(dst *int64, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got %q", key, v)
+	}
+	*dst = n
+	return nil
+}
+
+// envList reads a comma-separated list, dropping empty items.
+func envList(dst *[]string, key string) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+func envDuration(dst *time.Duration, key string) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s must be a duration such as 5s, got %q", key, v)
+	}
+	*dst = d
+	return nil
+}
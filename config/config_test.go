@@ -0,0 +1,54 @@
+package config
+
+import "testing"
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 3000 || cfg.DefaultLimit != 50 || cfg.MaxLimit != 200 || cfg.BodyLimit != 1<<20 {
+		t.Errorf("port=%d default_limit=%d max_limit=%d body_limit=%d, want 3000, 50, 200, %d",
+			cfg.Port, cfg.DefaultLimit, cfg.MaxLimit, cfg.BodyLimit, 1<<20)
+	}
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("DEFAULT_LIMIT", "20")
+	t.Setenv("MAX_LIMIT", "500")
+	t.Setenv("BODY_LIMIT", "4096")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 8080 || cfg.DefaultLimit != 20 || cfg.MaxLimit != 500 || cfg.BodyLimit != 4096 {
+		t.Errorf("port=%d default_limit=%d max_limit=%d body_limit=%d, want 8080, 20, 500, 4096",
+			cfg.Port, cfg.DefaultLimit, cfg.MaxLimit, cfg.BodyLimit)
+	}
+}
+
+func TestLoadRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		env, value string
+	}{
+		{"PORT", "0"},
+		{"PORT", "70000"},
+		{"PORT", "http"},
+		{"DEFAULT_LIMIT", "0"},
+		{"DEFAULT_LIMIT", "300"},
+		{"MAX_LIMIT", "-1"},
+		{"BODY_LIMIT", "0"},
+		{"SEED_DATA", "maybe"},
+		{"EVENTS_MAX_CLIENTS", "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			if _, err := Load(); err == nil {
+				t.Errorf("Load() with %s=%s succeeded, want an error", tt.env, tt.value)
+			}
+		})
+	}
+}
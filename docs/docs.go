@@ -25,9 +25,11 @@ const docTemplate = `{
     "paths": {
         "/books/": {
             "get": {
-                "description": "Get list of books with optional pagination",
+                "description": "Get list of books with optional pagination, by page or, when cursor is given, by cursor. The response carries the current store version, which can be passed back as sinceVersion to poll for changes. Polling answers with the books changed since then and, on every page, the IDs of the books deleted since then; when purges make that impossible, reset is true and every book is returned, to replace the client's copy.",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
                 ],
                 "tags": [
                     "books"
@@ -42,9 +44,98 @@ const docTemplate = `{
                     },
                     {
                         "type": "integer",
-                        "description": "Limit per page",
+                        "description": "Limit per page (max 200 unless MAX_LIMIT is set)",
                         "name": "limit",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books by this author, case-insensitive",
+                        "name": "author",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books in this ISO 639-1 language",
+                        "name": "language",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books with this tag, case-insensitive; repeat to require several",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books without this tag, case-insensitive; repeat to exclude several",
+                        "name": "withoutTag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only books with no tags at all",
+                        "name": "untagged",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return only the IDs of the books",
+                        "name": "idsOnly",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "stats"
+                        ],
+                        "type": "string",
+                        "description": "stats to add the catalog statistics of /books/stats, over every book whatever the filters",
+                        "name": "embed",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books changed after this store version; 304 if nothing changed, 400 if it is ahead of the store",
+                        "name": "sinceVersion",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or after this year",
+                        "name": "year_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or before this year",
+                        "name": "year_max",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor pagination: next_cursor of the previous page, or empty for the first page. The cursor carries the sort and filters of the first page, so later pages need only cursor and limit; sending one of them with another value is a 400. Cannot be combined with page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit per page used when limit is omitted",
+                        "name": "X-Default-Limit",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -53,16 +144,46 @@ const docTemplate = `{
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "Links to the first, prev, next and last pages, keeping the other query parameters; only next with a cursor"
+                            },
+                            "X-Total-Count": {
+                                "type": "int",
+                                "description": "Number of books matching the filters"
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "No Content, when the page is empty and EMPTY_LIST_NO_CONTENT is set"
+                    },
+                    "304": {
+                        "description": "Not Modified, when nothing changed since sinceVersion"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     }
                 }
             },
             "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
                 ],
                 "tags": [
                     "books"
@@ -75,179 +196,289 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Book"
+                            "$ref": "#/definitions/models.Book"
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Create the book even if one with the same title and author exists",
+                        "name": "force",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.Book"
+                            "$ref": "#/definitions/handlers.BookWriteResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Path of the new book"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ValidationErrorResponse"
                         }
                     }
                 }
-            }
-        },
-        "/books/{id}": {
-            "get": {
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Applies a merge patch, like PATCH /books/{id}, to every book the filter matches. Either all matching books are updated or, if any would become invalid or conflict, none is. An empty filter is refused unless all=true.",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack"
+                ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
                 ],
                 "tags": [
                     "books"
                 ],
-                "summary": "Get a book by ID",
+                "summary": "Partially update every book matching a filter",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Book ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Filter and update",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.FilterPatchRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Allow an empty filter, updating every book",
+                        "name": "all",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Book"
+                            "$ref": "#/definitions/handlers.FilterPatchResult"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     }
                 }
-            },
-            "put": {
+            }
+        },
+        "/books/batch-delete": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Moves every listed book to the trash in one step, or deletes them for good with hard=true. IDs that do not exist are reported, not treated as errors.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
                 ],
                 "tags": [
                     "books"
                 ],
-                "summary": "Replace a book (PUT)",
+                "summary": "Delete several books by ID",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Book ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Replace book",
-                        "name": "book",
+                        "description": "IDs to delete",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Book"
+                            "$ref": "#/definitions/handlers.BatchDeleteRequest"
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Delete permanently instead of moving to the trash",
+                        "name": "hard",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Book"
+                            "$ref": "#/definitions/handlers.BatchDeleteResult"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/books/bulk": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Validates every book first and inserts either all of them or none",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack"
+                ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
                 ],
                 "tags": [
                     "books"
                 ],
-                "summary": "Delete a book by ID",
+                "summary": "Create several books at once",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Book ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Books to create",
+                        "name": "books",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Book"
+                            }
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
-                    },
-                    "404": {
-                        "description": "Not Found",
+                    "201": {
+                        "description": "Created",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     }
                 }
             },
             "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Applies the same partial update to each listed book and reports the outcome per ID",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
                 ],
                 "tags": [
                     "books"
                 ],
-                "summary": "Partially update a book",
+                "summary": "Partially update several books by ID",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Book ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Update book",
-                        "name": "book",
+                        "description": "IDs and changes",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.Book"
+                            "$ref": "#/definitions/handlers.BulkPatchRequest"
                         }
                     }
                 ],
@@ -255,48 +486,2061 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Book"
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/handlers.BulkPatchResult"
+                                }
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.Book": {
-            "type": "object",
-            "properties": {
-                "author": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "string"
-                },
-                "title": {
-                    "type": "string"
-                },
-                "year": {
-                    "type": "integer"
-                }
-            }
+        },
+        "/books/by-author/{author}": {
+            "get": {
+                "description": "Books whose author matches exactly, ignoring case and surrounding spaces, paginated like the book list. An author without books gives an empty page.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get the books of an author",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Author name, URL-encoded",
+                        "name": "author",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit per page (max 200 unless MAX_LIMIT is set)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/count": {
+            "get": {
+                "description": "Number of books, optionally filtered, without returning them",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Count books",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only books whose title or author contains this text",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or after this year",
+                        "name": "year_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or before this year",
+                        "name": "year_max",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "integer"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/events": {
+            "get": {
+                "description": "WebSocket. Sends a JSON message for every book created, updated or deleted, in the order the changes happened. Restoring a book from the trash sends created. Clients that fall too far behind are disconnected. Connections beyond EVENTS_MAX_CLIENTS are refused with 503.",
+                "tags": [
+                    "books"
+                ],
+                "summary": "Stream book changes",
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BookEvent"
+                        }
+                    },
+                    "426": {
+                        "description": "Upgrade Required",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/exists": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Looks up each title/author pair, compared case-insensitively with surrounding whitespace ignored",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Check which books already exist",
+                "parameters": [
+                    {
+                        "description": "Title and author pairs",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BooksExistRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/handlers.BookExistsResult"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/export.csv": {
+            "get": {
+                "description": "Streams the books, optionally filtered and sorted like the book list, as a CSV attachment",
+                "produces": [
+                    "text/csv"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Export books as CSV",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books by this author, case-insensitive",
+                        "name": "author",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books in this ISO 639-1 language",
+                        "name": "language",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books with this tag, case-insensitive; repeat to require several",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books without this tag, case-insensitive; repeat to exclude several",
+                        "name": "withoutTag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only books with no tags at all",
+                        "name": "untagged",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or after this year",
+                        "name": "year_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or before this year",
+                        "name": "year_max",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "CSV with the columns id, title, author, year, isbn",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/export.ndjson": {
+            "get": {
+                "description": "Streams the books, optionally filtered and sorted like the book list, as one JSON object per line. With cursor, only a chunk of up to limit books is sent, followed by a last line holding just next_cursor, null after the last chunk, so a huge export can be fetched over several short requests. The cursor carries the sort and filters like the book list's.",
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Export books as NDJSON",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books by this author, case-insensitive",
+                        "name": "author",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books in this ISO 639-1 language",
+                        "name": "language",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books with this tag, case-insensitive; repeat to require several",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books without this tag, case-insensitive; repeat to exclude several",
+                        "name": "withoutTag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only books with no tags at all",
+                        "name": "untagged",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or after this year",
+                        "name": "year_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or before this year",
+                        "name": "year_max",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Chunked export: next_cursor of the previous chunk, or empty for the first one",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Books per chunk with cursor (max 200 unless MAX_LIMIT is set)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "A JSON book per line, then the next_cursor line with cursor",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/export.yaml": {
+            "get": {
+                "description": "The books, optionally filtered and sorted like the book list, as a YAML list with the fields of their JSON form. POST /books/import takes the file back.",
+                "produces": [
+                    "application/x-yaml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Export books as YAML",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books by this author, case-insensitive",
+                        "name": "author",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books in this ISO 639-1 language",
+                        "name": "language",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books with this tag, case-insensitive; repeat to require several",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books without this tag, case-insensitive; repeat to exclude several",
+                        "name": "withoutTag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only books with no tags at all",
+                        "name": "untagged",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or after this year",
+                        "name": "year_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or before this year",
+                        "name": "year_max",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "YAML list of books",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/geojson": {
+            "get": {
+                "description": "Books without coordinates are omitted",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get books with coordinates as GeoJSON",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GeoJSONFeatureCollection"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/import": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Imports a CSV file with a header row, or a JSON array or YAML list of books. Invalid rows are skipped and reported by line. Rows matching a book, or an earlier row, by normalized title and author are handled per onDuplicate.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Import books from a file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "CSV, JSON or YAML (application/x-yaml, .yaml or .yml) file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "append (default) or replace, which deletes every book first",
+                        "name": "mode",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "skip",
+                            "update",
+                            "create",
+                            "error"
+                        ],
+                        "type": "string",
+                        "description": "What to do with a row with the same normalized title and author as a book: skip (default), update it like PUT, create another, or error",
+                        "name": "onDuplicate",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ImportSummary"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/import/url": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Fetches a CSV, JSON or YAML file and imports it like /books/import. Only hosts listed in IMPORT_URL_HOSTS may be fetched, redirects included; the fetch is bounded by IMPORT_URL_TIMEOUT and BODY_LIMIT.",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Import books from a URL",
+                "parameters": [
+                    {
+                        "description": "File to import",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ImportURLRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "append (default) or replace, which deletes every book first",
+                        "name": "mode",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "skip",
+                            "update",
+                            "create",
+                            "error"
+                        ],
+                        "type": "string",
+                        "description": "What to do with a row with the same normalized title and author as a book: skip (default), update it like PUT, create another, or error",
+                        "name": "onDuplicate",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ImportSummary"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/random": {
+            "get": {
+                "description": "One book picked uniformly at random from those matching the filters, or with count up to that many distinct books. Unlike /books/sample the pick cannot be reproduced.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get a random book",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of distinct books (max 100); the response is then a list",
+                        "name": "count",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books by this author, case-insensitive",
+                        "name": "author",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only books in this ISO 639-1 language",
+                        "name": "language",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books with this tag, case-insensitive; repeat to require several",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only books without this tag, case-insensitive; repeat to exclude several",
+                        "name": "withoutTag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only books with no tags at all",
+                        "name": "untagged",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or after this year",
+                        "name": "year_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only books published in or before this year",
+                        "name": "year_max",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Book"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/sample": {
+            "get": {
+                "description": "The same seed always yields the same sample of the same catalog. Without a seed a random one is used; it is returned so the sample can be reproduced.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get a deterministic sample of books",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of books (default 5, max 100)",
+                        "name": "size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Seed for the sample",
+                        "name": "seed",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/search": {
+            "get": {
+                "description": "Case-insensitive substring match on title and author, paginated like the book list",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Search books by title or author",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Text to search for",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit per page (max 200 unless MAX_LIMIT is set)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/stats": {
+            "get": {
+                "description": "Aggregates over the whole catalog. The years and top author are null while no book has them; authors differing only in case count as one.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get catalog statistics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.CatalogStats"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/tags": {
+            "get": {
+                "description": "Every distinct tag with the number of books carrying it, most used first. Tags differing only in case count as one.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "List the tags in use",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/handlers.TagCount"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/books/top-authors": {
+            "get": {
+                "description": "Authors ranked by book count, ties broken alphabetically",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get the authors with the most books",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of authors (max 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/handlers.AuthorCount"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/trash": {
+            "get": {
+                "description": "Books in the trash, most recently deleted first",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "List deleted books",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/models.Book"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/books/{id}": {
+            "get": {
+                "description": "HEAD answers with the same status and headers, including ETag and Content-Length, without the body, to check that a book exists.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get a book by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Book"
+                        },
+                        "headers": {
+                            "Cache-Control": {
+                                "type": "string",
+                                "description": "no-cache, or max-age when CACHE_MAX_AGE is set"
+                            },
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the book"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified",
+                        "headers": {
+                            "Cache-Control": {
+                                "type": "string",
+                                "description": "no-cache, or max-age when CACHE_MAX_AGE is set"
+                            },
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the book"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "consumes": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Replace a book (PUT)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Replace book",
+                        "name": "book",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Book"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the change is based on",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BookWriteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ValidationErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Moves the book to the trash, from where it can be restored. With hard=true it is removed for good, even from the trash.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Delete a book by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Delete permanently instead of moving to the trash",
+                        "name": "hard",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "head": {
+                "description": "HEAD answers with the same status and headers, including ETag and Content-Length, without the body, to check that a book exists.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get a book by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Book"
+                        },
+                        "headers": {
+                            "Cache-Control": {
+                                "type": "string",
+                                "description": "no-cache, or max-age when CACHE_MAX_AGE is set"
+                            },
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the book"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified",
+                        "headers": {
+                            "Cache-Control": {
+                                "type": "string",
+                                "description": "no-cache, or max-age when CACHE_MAX_AGE is set"
+                            },
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the book"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "JSON merge patch: fields left out are unchanged and optional fields sent as null are cleared. Title and author cannot be null or blank. With Content-Type application/json-patch+json the body is instead a JSON Patch (RFC 6902) of add, replace, remove and test operations; a failed test answers 409.",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/json-patch+json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Partially update a book",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update book",
+                        "name": "book",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Book"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the change is based on",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BookWriteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/{id}/copies:adjust": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Adds delta to the copy count, refusing to go below zero",
+                "consumes": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Atomically adjust the number of copies of a book",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Change in copies",
+                        "name": "adjustment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.CopiesAdjustment"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/{id}/related": {
+            "get": {
+                "description": "Other books sharing the book's author or tags, ranked by overlap: a point for the same author and one per shared tag. With depth 2, books related to those are included after them.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Get books related to a book",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of related books",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "How many hops of relation to follow (default 1)",
+                        "name": "depth",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/models.Book"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/{id}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack",
+                    "application/xml"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Restore a deleted book",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BookWriteResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.AuthorCount": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "count": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.BatchDeleteRequest": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.BatchDeleteResult": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "type": "integer"
+                },
+                "not_found": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.BookEvent": {
+            "type": "object",
+            "properties": {
+                "book": {
+                    "$ref": "#/definitions/models.Book"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "created",
+                        "updated",
+                        "deleted"
+                    ],
+                    "example": "created"
+                }
+            }
+        },
+        "handlers.BookExistsResult": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "exists": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.BookFilter": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string",
+                    "example": "Martin Fowler"
+                },
+                "language": {
+                    "type": "string",
+                    "example": "en"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "year_max": {
+                    "type": "integer"
+                },
+                "year_min": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.BookKey": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.BookWriteResponse": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "copies": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "deleted_at": {
+                    "description": "DeletedAt is set while the book is in the trash.",
+                    "type": "string"
+                },
+                "generatedTitle": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "isbn": {
+                    "type": "string",
+                    "example": "9780134190440"
+                },
+                "language": {
+                    "type": "string",
+                    "example": "en"
+                },
+                "latitude": {
+                    "type": "number"
+                },
+                "longitude": {
+                    "type": "number"
+                },
+                "published_city": {
+                    "type": "string"
+                },
+                "seq": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "scifi",
+                        "classic"
+                    ]
+                },
+                "title": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                },
+                "views": {
+                    "description": "Views counts how often the book was fetched by ID. The store fills\nit in when the book is read and never saves it.",
+                    "type": "integer"
+                },
+                "warnings": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.BooksExistRequest": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.BookKey"
+                    }
+                }
+            }
+        },
+        "handlers.BulkPatchRequest": {
+            "type": "object",
+            "properties": {
+                "changes": {
+                    "type": "object"
+                },
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.BulkPatchResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "errors": {
+                    "description": "Errors lists the fields that failed validation for an invalid book.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.FieldError"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "updated"
+                }
+            }
+        },
+        "handlers.CatalogStats": {
+            "type": "object",
+            "properties": {
+                "authors": {
+                    "type": "integer"
+                },
+                "newest_year": {
+                    "type": "integer"
+                },
+                "oldest_year": {
+                    "type": "integer"
+                },
+                "top_author": {
+                    "$ref": "#/definitions/handlers.AuthorCount"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "with_year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.CopiesAdjustment": {
+            "type": "object",
+            "properties": {
+                "delta": {
+                    "type": "integer",
+                    "example": -1
+                }
+            }
+        },
+        "handlers.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "not_found"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "book not found"
+                }
+            }
+        },
+        "handlers.FilterPatchRequest": {
+            "type": "object",
+            "properties": {
+                "filter": {
+                    "$ref": "#/definitions/handlers.BookFilter"
+                },
+                "update": {
+                    "type": "object"
+                }
+            }
+        },
+        "handlers.FilterPatchResult": {
+            "type": "object",
+            "properties": {
+                "matched": {
+                    "description": "Matched counts the books the filter selected; Modified those of them\nthe update changed.",
+                    "type": "integer"
+                },
+                "modified": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.GeoJSONFeature": {
+            "type": "object",
+            "properties": {
+                "geometry": {
+                    "$ref": "#/definitions/handlers.GeoJSONPoint"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "properties": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "type": {
+                    "type": "string",
+                    "example": "Feature"
+                }
+            }
+        },
+        "handlers.GeoJSONFeatureCollection": {
+            "type": "object",
+            "properties": {
+                "features": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.GeoJSONFeature"
+                    }
+                },
+                "type": {
+                    "type": "string",
+                    "example": "FeatureCollection"
+                }
+            }
+        },
+        "handlers.GeoJSONPoint": {
+            "type": "object",
+            "properties": {
+                "coordinates": {
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "type": {
+                    "type": "string",
+                    "example": "Point"
+                }
+            }
+        },
+        "handlers.ImportError": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "line": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.ImportSummary": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "duplicates": {
+                    "type": "integer"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.ImportError"
+                    }
+                },
+                "imported": {
+                    "type": "integer"
+                },
+                "skipped": {
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.ImportURLRequest": {
+            "type": "object",
+            "properties": {
+                "format": {
+                    "type": "string",
+                    "enum": [
+                        "csv",
+                        "json",
+                        "yaml"
+                    ],
+                    "example": "csv"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://data.example.com/books.csv"
+                }
+            }
+        },
+        "handlers.TagCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "tag": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.ValidationErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "validation_error"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "validation failed"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.FieldError"
+                    }
+                }
+            }
+        },
+        "models.Book": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "copies": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "deleted_at": {
+                    "description": "DeletedAt is set while the book is in the trash.",
+                    "type": "string"
+                },
+                "generatedTitle": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "isbn": {
+                    "type": "string",
+                    "example": "9780134190440"
+                },
+                "language": {
+                    "type": "string",
+                    "example": "en"
+                },
+                "latitude": {
+                    "type": "number"
+                },
+                "longitude": {
+                    "type": "number"
+                },
+                "published_city": {
+                    "type": "string"
+                },
+                "seq": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "scifi",
+                        "classic"
+                    ]
+                },
+                "title": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                },
+                "views": {
+                    "description": "Views counts how often the book was fetched by ID. The store fills\nit in when the book is read and never saves it.",
+                    "type": "integer"
+                },
+                "year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string",
+                    "example": "title"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "title is required"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
         }
     }
 }`
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"demo-golang/config"
+	"demo-golang/models"
+)
+
+const testAPIKey = "s3cret"
+
+func TestResponseRedaction(t *testing.T) {
+	cfg := config.Default()
+	cfg.APIKey = testAPIKey
+	cfg.ResponseRedactFields = []string{"ID", "isbn"}
+	cfg.BookCacheSize = 10
+	app, s := newTestAppWithConfig(t, cfg)
+	b := create(t, s, models.Book{Title: "Clean Code", Author: "Robert C. Martin", ISBN: "9780132350884"})[0]
+
+	for _, target := range []string{"/api/books/", "/api/books/" + b.ID, "/api/books/export.ndjson"} {
+		for _, admin := range []bool{false, true} {
+			key := ""
+			if admin {
+				key = testAPIKey
+			}
+			status, body := doWithKey(t, app, http.MethodGet, target, "", key)
+			if status != http.StatusOK {
+				t.Fatalf("GET %s admin=%v: status = %d: %s", target, admin, status, body)
+			}
+			var book map[string]interface{}
+			if target == "/api/books/" {
+				var page struct {
+					Data []map[string]interface{} `json:"data"`
+				}
+				decode(t, body, &page)
+				book = page.Data[0]
+			} else {
+				decode(t, body, &book)
+			}
+			_, hasID := book["id"]
+			_, hasISBN := book["isbn"]
+			if hasID != admin || hasISBN != admin {
+				t.Errorf("GET %s admin=%v: id present = %v, isbn present = %v, want %v", target, admin, hasID, hasISBN, admin)
+			}
+			if book["title"] != "Clean Code" {
+				t.Errorf("GET %s admin=%v: title = %v, want it kept", target, admin, book["title"])
+			}
+		}
+	}
+
+	_, body := do(t, app, http.MethodGet, "/api/books/export.csv", "")
+	if header, _, _ := strings.Cut(string(body), "\n"); header != "title,author,year" {
+		t.Errorf("CSV header = %q, want %q", header, "title,author,year")
+	}
+	// A wrong key is treated like none.
+	_, body = doWithKey(t, app, http.MethodGet, "/api/books/"+b.ID, "", "wrong")
+	var book map[string]json.RawMessage
+	decode(t, body, &book)
+	if _, ok := book["id"]; ok {
+		t.Errorf("wrong key: id is present: %s", body)
+	}
+}
+
+func TestAPIKeyRequiredForWrites(t *testing.T) {
+	cfg := config.Default()
+	cfg.APIKey = testAPIKey
+	app, s := newTestAppWithConfig(t, cfg)
+	b := create(t, s, models.Book{Title: "Refactoring", Author: "Martin Fowler"})[0]
+
+	writes := []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin"}`, http.StatusCreated},
+		{http.MethodPut, "/api/books/" + b.ID, `{"title":"Refactoring","author":"Martin Fowler","year":1999}`, http.StatusOK},
+		{http.MethodPatch, "/api/books/" + b.ID, `{"year":2018}`, http.StatusOK},
+		{http.MethodDelete, "/api/books/" + b.ID, "", http.StatusNoContent},
+	}
+	for _, w := range writes {
+		for _, key := range []string{"", "wrong"} {
+			status, body := doWithKey(t, app, w.method, w.target, w.body, key)
+			if status != http.StatusUnauthorized {
+				t.Errorf("%s %s with key %q: status = %d, want %d: %s", w.method, w.target, key, status, http.StatusUnauthorized, body)
+				continue
+			}
+			var got ErrorResponse
+			decode(t, body, &got)
+			if got.Code != "unauthorized" {
+				t.Errorf("%s %s with key %q: code = %q, want unauthorized", w.method, w.target, key, got.Code)
+			}
+		}
+	}
+	if got, _ := s.Get(b.ID); got.Version != b.Version {
+		t.Errorf("book changed by unauthorized writes: got %+v, had %+v", got, b)
+	}
+	if all, _ := s.List(); len(all) != 1 {
+		t.Errorf("%d books after unauthorized create, want 1", len(all))
+	}
+
+	for _, target := range []string{"/api/books/", "/api/books/" + b.ID, "/api/books/count"} {
+		if status, body := do(t, app, http.MethodGet, target, ""); status != http.StatusOK {
+			t.Errorf("GET %s without key: status = %d, want %d: %s", target, status, http.StatusOK, body)
+		}
+	}
+
+	for _, w := range writes {
+		if status, body := doWithKey(t, app, w.method, w.target, w.body, testAPIKey); status != w.status {
+			t.Errorf("%s %s with the key: status = %d, want %d: %s", w.method, w.target, status, w.status, body)
+		}
+	}
+}
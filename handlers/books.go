@@ -0,0 +1,2049 @@
+package handlers
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"demo-golang/models"
+	"demo-golang/store"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// headerDefaultLimit lets a client choose its own page size for requests
+// that do not pass limit.
+const headerDefaultLimit = "X-Default-Limit"
+
+// headerTotalCount carries the number of books a paginated list matches.
+const headerTotalCount = "X-Total-Count"
+
+// fillGeneratedTitle gives b a placeholder title built from its author and
+// year when titles may be generated and b has none. Any generatedTitle flag
+// sent by the client is discarded.
+func (h *Handler) fillGeneratedTitle(b *models.Book) {
+	b.GeneratedTitle = false
+	if !h.cfg.GenerateMissingTitles || strings.TrimSpace(b.Title) != "" || b.Author == "" || b.Year == 0 {
+		return
+	}
+	b.Title = fmt.Sprintf("Untitled by %s (%d)", b.Author, b.Year)
+	b.GeneratedTitle = true
+}
+
+// minTitleLength is the title length below which a title is probably a
+// typo or placeholder.
+const minTitleLength = 3
+
+// bookWarnings reports data quality issues that are worth fixing but do not
+// make a book invalid.
+func bookWarnings(b *models.Book) []string {
+	var warnings []string
+	if len([]rune(strings.TrimSpace(b.Title))) < minTitleLength {
+		warnings = append(warnings, "title is suspiciously short")
+	}
+	if b.Year == 0 {
+		warnings = append(warnings, "year is missing")
+	}
+	return warnings
+}
+
+// BookWriteResponse is a stored book together with the warnings raised
+// while writing it.
+type BookWriteResponse struct {
+	models.Book
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func writeResponse(b models.Book) BookWriteResponse {
+	return BookWriteResponse{Book: b, Warnings: bookWarnings(&b)}
+}
+
+// getAllBooks godoc
+// @Summary Get all books
+// @Description Get list of books with optional pagination, by page or, when cursor is given, by cursor. The response carries the current store version, which can be passed back as sinceVersion to poll for changes. Polling answers with the books changed since then and, on every page, the IDs of the books deleted since then; when purges make that impossible, reset is true and every book is returned, to replace the client's copy.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param page query int false "Page number"
+// @Param limit query int false "Limit per page (max 200 unless MAX_LIMIT is set)"
+// @Param sort query string false "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending"
+// @Param author query string false "Only books by this author, case-insensitive"
+// @Param language query string false "Only books in this ISO 639-1 language"
+// @Param tag query []string false "Only books with this tag, case-insensitive; repeat to require several" collectionFormat(multi)
+// @Param withoutTag query []string false "Only books without this tag, case-insensitive; repeat to exclude several" collectionFormat(multi)
+// @Param untagged query bool false "Only books with no tags at all"
+// @Param idsOnly query bool false "Return only the IDs of the books"
+// @Param embed query string false "stats to add the catalog statistics of /books/stats, over every book whatever the filters" Enums(stats)
+// @Param sinceVersion query int false "Only books changed after this store version; 304 if nothing changed, 400 if it is ahead of the store"
+// @Param year_min query int false "Only books published in or after this year"
+// @Param year_max query int false "Only books published in or before this year"
+// @Param cursor query string false "Cursor pagination: next_cursor of the previous page, or empty for the first page. The cursor carries the sort and filters of the first page, so later pages need only cursor and limit; sending one of them with another value is a 400. Cannot be combined with page"
+// @Param X-Default-Limit header int false "Limit per page used when limit is omitted"
+// @Success 200 {object} map[string]interface{}
+// @Header 200 {string} Link "Links to the first, prev, next and last pages, keeping the other query parameters; only next with a cursor"
+// @Header 200 {int} X-Total-Count "Number of books matching the filters"
+// @Success 204 "No Content, when the page is empty and EMPTY_LIST_NO_CONTENT is set"
+// @Success 304 "Not Modified, when nothing changed since sinceVersion"
+// @Failure 400 {object} ErrorResponse
+// @Router /books/ [get]
+func (h *Handler) getAllBooks(c *fiber.Ctx) error {
+	page, limit, err := h.pageParams(c)
+	if err != nil {
+		return err
+	}
+	useCursor := c.Request().URI().QueryArgs().Has("cursor")
+	var after *models.Book
+	if useCursor {
+		if c.Query("page") != "" {
+			return newError(ErrBadRequest, "cursor cannot be combined with page")
+		}
+		if after, err = h.applyCursor(c); err != nil {
+			return err
+		}
+	}
+	filter, err := parseBookFilter(c)
+	if err != nil {
+		return err
+	}
+	sinceVersion := int64(-1)
+	if v := c.Query("sinceVersion"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return newError(ErrBadRequest, "sinceVersion must be a non-negative integer")
+		}
+		sinceVersion = n
+	}
+	embed := c.Query("embed")
+	if embed != "" && embed != "stats" {
+		return newError(ErrBadRequest, "embed must be stats")
+	}
+
+	var all []models.Book
+	var deleted []string
+	var version int64
+	complete := true
+	if sinceVersion < 0 {
+		all, version = h.store.List()
+	} else {
+		all, deleted, version, complete = h.store.ChangesSince(sinceVersion)
+		if sinceVersion > version {
+			return newError(ErrBadRequest, fmt.Sprintf("sinceVersion %d is ahead of the store version %d", sinceVersion, version))
+		}
+		if sinceVersion == version {
+			return c.SendStatus(http.StatusNotModified)
+		}
+	}
+
+	books := make([]models.Book, 0, len(all))
+	for _, v := range all {
+		if filter.matches(v) {
+			books = append(books, v)
+		}
+	}
+	order, err := bookOrder(c.Query("sort"))
+	if err != nil {
+		return newError(ErrBadRequest, err.Error())
+	}
+	sort.SliceStable(books, func(i, j int) bool { return order(books[i], books[j]) < 0 })
+
+	var paged []models.Book
+	var next string
+	if useCursor {
+		var more bool
+		paged, more = cursorSlice(books, after, limit, order)
+		if more {
+			next = h.encodeCursor(c, paged[len(paged)-1])
+		}
+		setCursorLinks(c, next, len(books))
+	} else {
+		paged = pageSlice(books, page, limit)
+		setPageLinks(c, page, limit, len(books))
+	}
+
+	if len(paged) == 0 && h.cfg.EmptyListNoContent {
+		return c.SendStatus(http.StatusNoContent)
+	}
+	var data interface{} = paged
+	if c.QueryBool("idsOnly") {
+		ids := make([]string, len(paged))
+		for i, b := range paged {
+			ids[i] = b.ID
+		}
+		data = ids
+	}
+	var body fiber.Map
+	if useCursor {
+		body = h.cursorEnvelope(data, limit, len(books), next)
+	} else {
+		body = h.pageEnvelope(data, page, limit, len(books))
+	}
+	body["version"] = version
+	if sinceVersion >= 0 {
+		body["deleted"] = deleted
+		body["reset"] = !complete
+	}
+	if embed == "stats" {
+		if sinceVersion >= 0 {
+			all, _ = h.store.List()
+		}
+		body["stats"] = catalogStats(all)
+	}
+	return h.sendJSON(c, http.StatusOK, body)
+}
+
+// yearRange reads the year_min and year_max filters. A bound that is not
+// given is returned as 0 and leaves that side of the range open.
+func yearRange(c *fiber.Ctx) (yearMin, yearMax int, err error) {
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"year_min", &yearMin}, {"year_max", &yearMax}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, newError(ErrBadRequest, p.name+" must be a positive integer")
+		}
+		*p.dst = n
+	}
+	if yearMin > 0 && yearMax > 0 && yearMin > yearMax {
+		return 0, 0, newError(ErrBadRequest, "year_min must not be greater than year_max")
+	}
+	return yearMin, yearMax, nil
+}
+
+// bookFilter holds the filters of the book list that other endpoints
+// returning books in bulk accept too.
+type bookFilter struct {
+	// author matches exactly, ignoring case and surrounding spaces.
+	author           string
+	language         string
+	yearMin, yearMax int
+	// tags must all be on a book for it to match and withoutTags must
+	// all be absent, compared case-insensitively.
+	tags        []string
+	withoutTags []string
+	// untagged matches only books with no tags at all.
+	untagged bool
+}
+
+func parseBookFilter(c *fiber.Ctx) (bookFilter, error) {
+	f := bookFilter{
+		author:   normalizeKey(c.Query("author")),
+		language: normalizeKey(c.Query("language")),
+	}
+	for _, v := range c.Context().QueryArgs().PeekMulti("tag") {
+		if tag := strings.TrimSpace(string(v)); tag != "" {
+			f.tags = append(f.tags, tag)
+		}
+	}
+	for _, v := range c.Context().QueryArgs().PeekMulti("withoutTag") {
+		if tag := strings.TrimSpace(string(v)); tag != "" {
+			f.withoutTags = append(f.withoutTags, tag)
+		}
+	}
+	f.untagged = c.QueryBool("untagged")
+	var err error
+	f.yearMin, f.yearMax, err = yearRange(c)
+	return f, err
+}
+
+func (f bookFilter) matches(b models.Book) bool {
+	if f.author != "" && normalizeKey(b.Author) != f.author {
+		return false
+	}
+	if f.language != "" && b.Language != f.language {
+		return false
+	}
+	for _, tag := range f.tags {
+		if !hasTag(b, tag) {
+			return false
+		}
+	}
+	for _, tag := range f.withoutTags {
+		if hasTag(b, tag) {
+			return false
+		}
+	}
+	if f.untagged && len(b.Tags) > 0 {
+		return false
+	}
+	return inYearRange(b, f.yearMin, f.yearMax)
+}
+
+func hasTag(b models.Book, tag string) bool {
+	for _, t := range b.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// exportBooksCSV godoc
+// @Summary Export books as CSV
+// @Description Streams the books, optionally filtered and sorted like the book list, as a CSV attachment
+// @Tags books
+// @Produce text/csv
+// @Param sort query string false "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending"
+// @Param author query string false "Only books by this author, case-insensitive"
+// @Param language query string false "Only books in this ISO 639-1 language"
+// @Param tag query []string false "Only books with this tag, case-insensitive; repeat to require several" collectionFormat(multi)
+// @Param withoutTag query []string false "Only books without this tag, case-insensitive; repeat to exclude several" collectionFormat(multi)
+// @Param untagged query bool false "Only books with no tags at all"
+// @Param year_min query int false "Only books published in or after this year"
+// @Param year_max query int false "Only books published in or before this year"
+// @Success 200 {string} string "CSV with the columns id, title, author, year, isbn"
+// @Failure 400 {object} ErrorResponse
+// @Router /books/export.csv [get]
+func (h *Handler) exportBooksCSV(c *fiber.Ctx) error {
+	books, err := h.exportedBooks(c)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="books.csv"`)
+	// Rows are written as the client reads them instead of building the
+	// whole file in memory first.
+	columns := []string{"id", "title", "author", "year", "isbn"}
+	redacted := h.redactedFields(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		cw := csv.NewWriter(w)
+		_ = cw.Write(omitColumns(columns, columns, redacted))
+		for _, b := range books {
+			year := ""
+			if b.Year != 0 {
+				year = strconv.Itoa(b.Year)
+			}
+			if err := cw.Write(omitColumns([]string{b.ID, b.Title, b.Author, year, b.ISBN}, columns, redacted)); err != nil {
+				return
+			}
+		}
+		cw.Flush()
+	})
+	return nil
+}
+
+// omitColumns returns row without the values of the columns in redacted.
+func omitColumns(row, columns []string, redacted map[string]bool) []string {
+	if redacted == nil {
+		return row
+	}
+	kept := make([]string, 0, len(row))
+	for i, col := range columns {
+		if !redacted[col] {
+			kept = append(kept, row[i])
+		}
+	}
+	return kept
+}
+
+// exportBooksYAML godoc
+// @Summary Export books as YAML
+// @Description The books, optionally filtered and sorted like the book list, as a YAML list with the fields of their JSON form. POST /books/import takes the file back.
+// @Tags books
+// @Produce application/x-yaml
+// @Param sort query string false "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending"
+// @Param author query string false "Only books by this author, case-insensitive"
+// @Param language query string false "Only books in this ISO 639-1 language"
+// @Param tag query []string false "Only books with this tag, case-insensitive; repeat to require several" collectionFormat(multi)
+// @Param withoutTag query []string false "Only books without this tag, case-insensitive; repeat to exclude several" collectionFormat(multi)
+// @Param untagged query bool false "Only books with no tags at all"
+// @Param year_min query int false "Only books published in or after this year"
+// @Param year_max query int false "Only books published in or before this year"
+// @Success 200 {string} string "YAML list of books"
+// @Failure 400 {object} ErrorResponse
+// @Router /books/export.yaml [get]
+func (h *Handler) exportBooksYAML(c *fiber.Ctx) error {
+	books, err := h.exportedBooks(c)
+	if err != nil {
+		return err
+	}
+	// Go by way of the JSON form, as for MessagePack, so the keys are the
+	// JSON field names.
+	v, err := jsonValue(books)
+	if err != nil {
+		return err
+	}
+	if redacted := h.redactedFields(c); redacted != nil {
+		v = stripFields(v, redacted)
+	}
+	body, err := yaml.Marshal(msgpackValue(v))
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, mimeApplicationYAML)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="books.yaml"`)
+	return c.Send(body)
+}
+
+// exportBooksNDJSON godoc
+// @Summary Export books as NDJSON
+// @Description Streams the books, optionally filtered and sorted like the book list, as one JSON object per line. With cursor, only a chunk of up to limit books is sent, followed by a last line holding just next_cursor, null after the last chunk, so a huge export can be fetched over several short requests. The cursor carries the sort and filters like the book list's.
+// @Tags books
+// @Produce application/x-ndjson
+// @Param sort query string false "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending"
+// @Param author query string false "Only books by this author, case-insensitive"
+// @Param language query string false "Only books in this ISO 639-1 language"
+// @Param tag query []string false "Only books with this tag, case-insensitive; repeat to require several" collectionFormat(multi)
+// @Param withoutTag query []string false "Only books without this tag, case-insensitive; repeat to exclude several" collectionFormat(multi)
+// @Param untagged query bool false "Only books with no tags at all"
+// @Param year_min query int false "Only books published in or after this year"
+// @Param year_max query int false "Only books published in or before this year"
+// @Param cursor query string false "Chunked export: next_cursor of the previous chunk, or empty for the first one"
+// @Param limit query int false "Books per chunk with cursor (max 200 unless MAX_LIMIT is set)"
+// @Success 200 {string} string "A JSON book per line, then the next_cursor line with cursor"
+// @Failure 400 {object} ErrorResponse
+// @Router /books/export.ndjson [get]
+func (h *Handler) exportBooksNDJSON(c *fiber.Ctx) error {
+	chunked := c.Request().URI().QueryArgs().Has("cursor")
+	var after *models.Book
+	var limit int
+	var err error
+	if chunked {
+		if _, limit, err = h.pageParams(c); err != nil {
+			return err
+		}
+		if after, err = h.applyCursor(c); err != nil {
+			return err
+		}
+	}
+	books, err := h.exportedBooks(c)
+	if err != nil {
+		return err
+	}
+	var next interface{}
+	if chunked {
+		order, _ := bookOrder(c.Query("sort"))
+		var more bool
+		books, more = cursorSlice(books, after, limit, order)
+		if more {
+			next = h.encodeCursor(c, books[len(books)-1])
+		}
+	}
+
+	redacted := h.redactedFields(c)
+	c.Set(fiber.HeaderContentType, mimeApplicationNDJSON)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		enc := json.NewEncoder(w)
+		for _, b := range books {
+			var line interface{} = b
+			if redacted != nil {
+				v, err := jsonValue(b)
+				if err != nil {
+					return
+				}
+				line = stripFields(v, redacted)
+			}
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+		}
+		if chunked {
+			_ = enc.Encode(fiber.Map{h.cfg.Envelope.NextCursor: next})
+		}
+	})
+	return nil
+}
+
+// exportedBooks returns the books an export asks for, filtered and sorted
+// like the book list.
+func (h *Handler) exportedBooks(c *fiber.Ctx) ([]models.Book, error) {
+	filter, err := parseBookFilter(c)
+	if err != nil {
+		return nil, err
+	}
+	all, _ := h.store.List()
+	books := make([]models.Book, 0, len(all))
+	for _, b := range all {
+		if filter.matches(b) {
+			books = append(books, b)
+		}
+	}
+	if err := sortBooks(books, c.Query("sort")); err != nil {
+		return nil, newError(ErrBadRequest, err.Error())
+	}
+	return books, nil
+}
+
+// inYearRange reports whether b falls within the bounds read by yearRange.
+// Books without a year only match when neither bound is set.
+func inYearRange(b models.Book, yearMin, yearMax int) bool {
+	if yearMin == 0 && yearMax == 0 {
+		return true
+	}
+	return b.Year != 0 && (yearMin == 0 || b.Year >= yearMin) && (yearMax == 0 || b.Year <= yearMax)
+}
+
+// matchesSearch reports whether the title or author of b contains q, which
+// must already be trimmed and lower-cased.
+func matchesSearch(b models.Book, q string) bool {
+	return strings.Contains(strings.ToLower(b.Title), q) || strings.Contains(strings.ToLower(b.Author), q)
+}
+
+// countBooks godoc
+// @Summary Count books
+// @Description Number of books, optionally filtered, without returning them
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param q query string false "Only books whose title or author contains this text"
+// @Param year_min query int false "Only books published in or after this year"
+// @Param year_max query int false "Only books published in or before this year"
+// @Success 200 {object} map[string]int
+// @Failure 400 {object} ErrorResponse
+// @Router /books/count [get]
+func (h *Handler) countBooks(c *fiber.Ctx) error {
+	q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	yearMin, yearMax, err := yearRange(c)
+	if err != nil {
+		return err
+	}
+
+	var total int
+	if q == "" && yearMin == 0 && yearMax == 0 {
+		total = h.store.Len()
+	} else {
+		all, _ := h.store.List()
+		for _, b := range all {
+			if inYearRange(b, yearMin, yearMax) && (q == "" || matchesSearch(b, q)) {
+				total++
+			}
+		}
+	}
+
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"total": total})
+}
+
+// pageParams reads the requested page and page size, falling back to the
+// first page and the client's or the server's default size. A page size
+// above the configured maximum is lowered to it.
+func (h *Handler) pageParams(c *fiber.Ctx) (page, limit int, err error) {
+	defaultLimit := h.cfg.DefaultLimit
+	if v, err := strconv.Atoi(c.Get(headerDefaultLimit)); err == nil && v > 0 {
+		defaultLimit = v
+	}
+	page, limit = 1, defaultLimit
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil {
+			return 0, 0, newError(ErrBadRequest, "page must be an integer")
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			return 0, 0, newError(ErrBadRequest, "limit must be an integer")
+		}
+	}
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = defaultLimit
+	}
+	return page, min(limit, h.cfg.MaxLimit), nil
+}
+
+// pageSlice returns the books on the given page; it is empty past the end.
+// The page is never nil, so it serializes as [] rather than null.
+func pageSlice(books []models.Book, page, limit int) []models.Book {
+	if books == nil {
+		return []models.Book{}
+	}
+	// Compare page numbers rather than offsets: (page-1)*limit overflows
+	// for huge pages.
+	if page-1 >= (len(books)+limit-1)/limit {
+		return books[len(books):]
+	}
+	start := (page - 1) * limit
+	end := start + limit
+	if end > len(books) {
+		end = len(books)
+	}
+	return books[start:end]
+}
+
+// setPageLinks sets the X-Total-Count header and a Link header (RFC 8288)
+// to the first, previous, next and last pages. The links keep every other
+// query parameter of the request, so filters carry over.
+func setPageLinks(c *fiber.Ctx, page, limit, total int) {
+	last := max(1, (total+limit-1)/limit)
+	links := []string{pageLink(c, "page", strconv.Itoa(1), "first")}
+	if page > 1 {
+		links = append(links, pageLink(c, "page", strconv.Itoa(min(page-1, last)), "prev"))
+	}
+	if page < last {
+		links = append(links, pageLink(c, "page", strconv.Itoa(page+1), "next"))
+	}
+	links = append(links, pageLink(c, "page", strconv.Itoa(last), "last"))
+	c.Set(headerTotalCount, strconv.Itoa(total))
+	c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+}
+
+// setCursorLinks is setPageLinks for cursor pagination, which can only link
+// to the next page.
+func setCursorLinks(c *fiber.Ctx, next string, total int) {
+	c.Set(headerTotalCount, strconv.Itoa(total))
+	if next != "" {
+		c.Set(fiber.HeaderLink, pageLink(c, "cursor", next, "next"))
+	}
+}
+
+// pageLink returns a Link header entry for the request's URL with the
+// query parameter key set to value.
+func pageLink(c *fiber.Ctx, key, value, rel string) string {
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	query.Set(key, value)
+	return fmt.Sprintf(`<%s?%s>; rel="%s"`, c.Path(), query.Encode(), rel)
+}
+
+// searchBooks godoc
+// @Summary Search books by title or author
+// @Description Case-insensitive substring match on title and author, paginated like the book list
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param q query string true "Text to search for"
+// @Param page query int false "Page number"
+// @Param limit query int false "Limit per page (max 200 unless MAX_LIMIT is set)"
+// @Param sort query string false "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Router /books/search [get]
+func (h *Handler) searchBooks(c *fiber.Ctx) error {
+	q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if q == "" {
+		return newError(ErrBadRequest, "q is required")
+	}
+	page, limit, err := h.pageParams(c)
+	if err != nil {
+		return err
+	}
+
+	all, _ := h.store.List()
+	books := make([]models.Book, 0)
+	for _, b := range all {
+		if matchesSearch(b, q) {
+			books = append(books, b)
+		}
+	}
+
+	if err := sortBooks(books, c.Query("sort")); err != nil {
+		return newError(ErrBadRequest, err.Error())
+	}
+	return h.sendJSON(c, http.StatusOK, h.pageEnvelope(pageSlice(books, page, limit), page, limit, len(books)))
+}
+
+// getBooksByAuthor godoc
+// @Summary Get the books of an author
+// @Description Books whose author matches exactly, ignoring case and surrounding spaces, paginated like the book list. An author without books gives an empty page.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param author path string true "Author name, URL-encoded"
+// @Param page query int false "Page number"
+// @Param limit query int false "Limit per page (max 200 unless MAX_LIMIT is set)"
+// @Param sort query string false "Sort field: title, author, year, seq, created_at, updated_at or views; prefix with - for descending"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Router /books/by-author/{author} [get]
+func (h *Handler) getBooksByAuthor(c *fiber.Ctx) error {
+	author, err := url.PathUnescape(c.Params("author"))
+	if err != nil {
+		return newError(ErrBadRequest, "invalid author")
+	}
+	author = normalizeKey(author)
+	if author == "" {
+		return newError(ErrBadRequest, "author is required")
+	}
+	page, limit, err := h.pageParams(c)
+	if err != nil {
+		return err
+	}
+
+	all, _ := h.store.List()
+	books := make([]models.Book, 0)
+	for _, b := range all {
+		if normalizeKey(b.Author) == author {
+			books = append(books, b)
+		}
+	}
+
+	if err := sortBooks(books, c.Query("sort")); err != nil {
+		return newError(ErrBadRequest, err.Error())
+	}
+	return h.sendJSON(c, http.StatusOK, h.pageEnvelope(pageSlice(books, page, limit), page, limit, len(books)))
+}
+
+// bookSortFields are the fields the book list can be sorted by, each with
+// a function comparing two books on that field.
+var bookSortFields = map[string]func(a, b models.Book) int{
+	"title":      func(a, b models.Book) int { return strings.Compare(normalizeKey(a.Title), normalizeKey(b.Title)) },
+	"author":     func(a, b models.Book) int { return strings.Compare(normalizeKey(a.Author), normalizeKey(b.Author)) },
+	"year":       func(a, b models.Book) int { return cmp.Compare(a.Year, b.Year) },
+	"seq":        func(a, b models.Book) int { return cmp.Compare(a.Seq, b.Seq) },
+	"created_at": func(a, b models.Book) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"updated_at": func(a, b models.Book) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+	"views":      func(a, b models.Book) int { return cmp.Compare(a.Views, b.Views) },
+}
+
+// bookOrder returns the comparison sortBooks orders by for spec.
+func bookOrder(spec string) (func(a, b models.Book) int, error) {
+	field, desc := strings.CutPrefix(spec, "-")
+	compare := func(a, b models.Book) int { return 0 }
+	if field != "" {
+		var ok bool
+		if compare, ok = bookSortFields[field]; !ok {
+			return nil, fmt.Errorf("cannot sort by %q: must be one of title, author, year, seq, created_at, updated_at, views", field)
+		}
+	}
+	return func(a, b models.Book) int {
+		c := compare(a, b)
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	}, nil
+}
+
+// sortBooks orders books by spec, a field name optionally prefixed with "-"
+// for descending order. Ties, and an empty spec, fall back to ascending ID
+// so pages are stable between requests.
+func sortBooks(books []models.Book, spec string) error {
+	order, err := bookOrder(spec)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(books, func(i, j int) bool { return order(books[i], books[j]) < 0 })
+	return nil
+}
+
+// pageEnvelope wraps a page of results in the list response, using the key
+// names from the configured envelope.
+func (h *Handler) pageEnvelope(data interface{}, page, limit, total int) fiber.Map {
+	keys := h.cfg.Envelope
+	return fiber.Map{
+		keys.Data:       data,
+		keys.Page:       page,
+		keys.Limit:      limit,
+		keys.Total:      total,
+		keys.TotalPages: (total + limit - 1) / limit,
+	}
+}
+
+// cursorEnvelope wraps a page of cursor pagination in the list response.
+// The next cursor is null on the last page.
+func (h *Handler) cursorEnvelope(data interface{}, limit, total int, next string) fiber.Map {
+	keys := h.cfg.Envelope
+	body := fiber.Map{
+		keys.Data:       data,
+		keys.Limit:      limit,
+		keys.Total:      total,
+		keys.NextCursor: nil,
+	}
+	if next != "" {
+		body[keys.NextCursor] = next
+	}
+	return body
+}
+
+// getBookByID godoc
+// @Summary Get a book by ID
+// @Description HEAD answers with the same status and headers, including ETag and Content-Length, without the body, to check that a book exists.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param id path string true "Book ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.Book
+// @Success 304
+// @Header 200,304 {string} ETag "Entity tag of the book"
+// @Header 200,304 {string} Cache-Control "no-cache, or max-age when CACHE_MAX_AGE is set"
+// @Failure 404 {object} ErrorResponse
+// @Router /books/{id} [get]
+// @Router /books/{id} [head]
+func (h *Handler) getBookByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+	b, ok := h.store.Get(id)
+	if !ok {
+		return newError(ErrNotFound, "book not found")
+	}
+	if c.Method() == fiber.MethodGet {
+		if n := h.store.AddView(b.ID); n > 0 {
+			b.Views = n
+		}
+	}
+	etag := bookETag(b)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, cacheControl(h.cfg.CacheMaxAge))
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" && etagMatches(inm, etag) {
+		return c.SendStatus(http.StatusNotModified)
+	}
+	if h.cache != nil && !h.cfg.ResponseMeta && responseFormat(c) == fiber.MIMEApplicationJSON && h.redactedFields(c) == nil {
+		return h.sendCachedBook(c, b)
+	}
+	return h.sendJSON(c, http.StatusOK, b)
+}
+
+// sendCachedBook sends b as JSON, marshaling it only if the cache does not
+// hold this version of it yet. The view count changes with every read, so
+// it is left out of the cached body and added when sending.
+func (h *Handler) sendCachedBook(c *fiber.Ctx, b models.Book) error {
+	body, ok := h.cache.get(b.ID, b.Version)
+	if ok {
+		h.metrics.cacheHits.Inc()
+	} else {
+		h.metrics.cacheMisses.Inc()
+		unviewed := b
+		unviewed.Views = 0
+		var err error
+		if body, err = json.Marshal(unviewed); err != nil {
+			return err
+		}
+		h.cache.put(b.ID, b.Version, body)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if b.Views == 0 {
+		return c.Status(http.StatusOK).Send(body)
+	}
+	// body is a JSON object; insert the views before its closing brace.
+	viewed := make([]byte, 0, len(body)+32)
+	viewed = append(viewed, body[:len(body)-1]...)
+	viewed = append(viewed, `,"views":`...)
+	viewed = strconv.AppendInt(viewed, b.Views, 10)
+	viewed = append(viewed, '}')
+	return c.Status(http.StatusOK).Send(viewed)
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type" example:"FeatureCollection"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type" example:"Feature"`
+	ID         string                 `json:"id"`
+	Geometry   GeoJSONPoint           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONPoint struct {
+	Type        string     `json:"type" example:"Point"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// getBooksGeoJSON godoc
+// @Summary Get books with coordinates as GeoJSON
+// @Description Books without coordinates are omitted
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Success 200 {object} GeoJSONFeatureCollection
+// @Router /books/geojson [get]
+func (h *Handler) getBooksGeoJSON(c *fiber.Ctx) error {
+	all, _ := h.store.List()
+	features := make([]GeoJSONFeature, 0)
+	for _, b := range all {
+		if b.Latitude == nil || b.Longitude == nil {
+			continue
+		}
+		features = append(features, GeoJSONFeature{
+			Type: "Feature",
+			ID:   b.ID,
+			Geometry: GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{*b.Longitude, *b.Latitude},
+			},
+			Properties: map[string]interface{}{
+				"title":          b.Title,
+				"author":         b.Author,
+				"year":           b.Year,
+				"published_city": b.PublishedCity,
+			},
+		})
+	}
+
+	sort.Slice(features, func(i, j int) bool { return features[i].ID < features[j].ID })
+	if err := h.sendJSON(c, http.StatusOK, GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}); err != nil {
+		return err
+	}
+	if c.GetRespHeader(fiber.HeaderContentType) == fiber.MIMEApplicationJSON {
+		c.Set(fiber.HeaderContentType, "application/geo+json")
+	}
+	return nil
+}
+
+const maxTopAuthors = 100
+
+type AuthorCount struct {
+	Author string `json:"author"`
+	Count  int    `json:"count"`
+}
+
+// getTopAuthors godoc
+// @Summary Get the authors with the most books
+// @Description Authors ranked by book count, ties broken alphabetically
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param limit query int false "Number of authors (max 100)"
+// @Success 200 {object} map[string][]AuthorCount
+// @Failure 400 {object} ErrorResponse
+// @Router /books/top-authors [get]
+func (h *Handler) getTopAuthors(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "10"))
+	if err != nil || limit < 1 || limit > maxTopAuthors {
+		return newError(ErrBadRequest, "limit must be between 1 and "+strconv.Itoa(maxTopAuthors))
+	}
+
+	all, _ := h.store.List()
+	authors := rankAuthors(all)
+	if len(authors) > limit {
+		authors = authors[:limit]
+	}
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"data": authors})
+}
+
+// rankAuthors counts the books of each author, treating spellings that
+// differ only in case as one author, and ranks them by book count, ties
+// broken alphabetically.
+func rankAuthors(books []models.Book) []AuthorCount {
+	counts := make(map[string]*AuthorCount)
+	for _, b := range books {
+		key := normalizeKey(b.Author)
+		name := strings.TrimSpace(b.Author)
+		ac, ok := counts[key]
+		if !ok {
+			ac = &AuthorCount{Author: name}
+			counts[key] = ac
+		} else if name < ac.Author {
+			// Spellings differing only in case count as one author; report
+			// the same one regardless of map order.
+			ac.Author = name
+		}
+		ac.Count++
+	}
+
+	authors := make([]AuthorCount, 0, len(counts))
+	for _, ac := range counts {
+		authors = append(authors, *ac)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].Count != authors[j].Count {
+			return authors[i].Count > authors[j].Count
+		}
+		return normalizeKey(authors[i].Author) < normalizeKey(authors[j].Author)
+	})
+	return authors
+}
+
+type CatalogStats struct {
+	Total      int          `json:"total"`
+	WithYear   int          `json:"with_year"`
+	OldestYear *int         `json:"oldest_year"`
+	NewestYear *int         `json:"newest_year"`
+	Authors    int          `json:"authors"`
+	TopAuthor  *AuthorCount `json:"top_author"`
+}
+
+// getStats godoc
+// @Summary Get catalog statistics
+// @Description Aggregates over the whole catalog. The years and top author are null while no book has them; authors differing only in case count as one.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Success 200 {object} CatalogStats
+// @Router /books/stats [get]
+func (h *Handler) getStats(c *fiber.Ctx) error {
+	all, _ := h.store.List()
+	return h.sendJSON(c, http.StatusOK, catalogStats(all))
+}
+
+// catalogStats aggregates the statistics of the books in all.
+func catalogStats(all []models.Book) CatalogStats {
+	stats := CatalogStats{Total: len(all)}
+	for _, b := range all {
+		if b.Year == 0 {
+			continue
+		}
+		year := b.Year
+		stats.WithYear++
+		if stats.OldestYear == nil || year < *stats.OldestYear {
+			stats.OldestYear = &year
+		}
+		if stats.NewestYear == nil || year > *stats.NewestYear {
+			stats.NewestYear = &year
+		}
+	}
+	authors := rankAuthors(all)
+	stats.Authors = len(authors)
+	if len(authors) > 0 {
+		stats.TopAuthor = &authors[0]
+	}
+	return stats
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// getTags godoc
+// @Summary List the tags in use
+// @Description Every distinct tag with the number of books carrying it, most used first. Tags differing only in case count as one.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Success 200 {object} map[string][]TagCount
+// @Router /books/tags [get]
+func (h *Handler) getTags(c *fiber.Ctx) error {
+	all, _ := h.store.List()
+	counts := make(map[string]*TagCount)
+	for _, b := range all {
+		for _, tag := range b.Tags {
+			key := strings.ToLower(tag)
+			tc, ok := counts[key]
+			if !ok {
+				tc = &TagCount{Tag: tag}
+				counts[key] = tc
+			} else if tag < tc.Tag {
+				// Report the same spelling regardless of map order.
+				tc.Tag = tag
+			}
+			tc.Count++
+		}
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for _, tc := range counts {
+		tags = append(tags, *tc)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return strings.ToLower(tags[i].Tag) < strings.ToLower(tags[j].Tag)
+	})
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"data": tags})
+}
+
+const maxSampleSize = 100
+
+// getSample godoc
+// @Summary Get a deterministic sample of books
+// @Description The same seed always yields the same sample of the same catalog. Without a seed a random one is used; it is returned so the sample can be reproduced.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param size query int false "Number of books (default 5, max 100)"
+// @Param seed query int false "Seed for the sample"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Router /books/sample [get]
+func (h *Handler) getSample(c *fiber.Ctx) error {
+	size, err := strconv.Atoi(c.Query("size", "5"))
+	if err != nil || size < 1 || size > maxSampleSize {
+		return newError(ErrBadRequest, "size must be between 1 and "+strconv.Itoa(maxSampleSize))
+	}
+	seed := rand.Int63()
+	if v := c.Query("seed"); v != "" {
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return newError(ErrBadRequest, "seed must be an integer")
+		}
+	}
+
+	books, _ := h.store.List()
+	if books == nil {
+		books = []models.Book{}
+	}
+
+	// Map iteration order is random, so fix the order before shuffling.
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(books), func(i, j int) { books[i], books[j] = books[j], books[i] })
+	if len(books) > size {
+		books = books[:size]
+	}
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"data": books, "seed": seed})
+}
+
+// getRandomBook godoc
+// @Summary Get a random book
+// @Description One book picked uniformly at random from those matching the filters, or with count up to that many distinct books. Unlike /books/sample the pick cannot be reproduced.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param count query int false "Number of distinct books (max 100); the response is then a list"
+// @Param author query string false "Only books by this author, case-insensitive"
+// @Param language query string false "Only books in this ISO 639-1 language"
+// @Param tag query []string false "Only books with this tag, case-insensitive; repeat to require several" collectionFormat(multi)
+// @Param withoutTag query []string false "Only books without this tag, case-insensitive; repeat to exclude several" collectionFormat(multi)
+// @Param untagged query bool false "Only books with no tags at all"
+// @Param year_min query int false "Only books published in or after this year"
+// @Param year_max query int false "Only books published in or before this year"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /books/random [get]
+func (h *Handler) getRandomBook(c *fiber.Ctx) error {
+	count := 0
+	if v := c.Query("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSampleSize {
+			return newError(ErrBadRequest, "count must be between 1 and "+strconv.Itoa(maxSampleSize))
+		}
+		count = n
+	}
+	filter, err := parseBookFilter(c)
+	if err != nil {
+		return err
+	}
+
+	all, _ := h.store.List()
+	books := make([]models.Book, 0, len(all))
+	for _, b := range all {
+		if filter.matches(b) {
+			books = append(books, b)
+		}
+	}
+	if len(books) == 0 {
+		return newError(ErrNotFound, "no book matches the filters")
+	}
+	// The global source of math/rand is seeded randomly at startup, so picks
+	// differ between restarts.
+	if count == 0 {
+		return h.sendJSON(c, http.StatusOK, books[rand.Intn(len(books))])
+	}
+	rand.Shuffle(len(books), func(i, j int) { books[i], books[j] = books[j], books[i] })
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"data": books[:min(count, len(books))]})
+}
+
+// relatedBooks godoc
+// @Summary Get books related to a book
+// @Description Other books sharing the book's author or tags, ranked by overlap: a point for the same author and one per shared tag. With depth 2, books related to those are included after them.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param id path string true "Book ID"
+// @Param limit query int false "Maximum number of related books"
+// @Param depth query int false "How many hops of relation to follow (default 1)"
+// @Success 200 {object} map[string][]models.Book
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /books/{id}/related [get]
+func (h *Handler) relatedBooks(c *fiber.Ctx) error {
+	id := c.Params("id")
+	limit, err := strconv.Atoi(c.Query("limit", "10"))
+	if err != nil || limit < 1 {
+		return newError(ErrBadRequest, "limit must be a positive integer")
+	}
+	depth, err := strconv.Atoi(c.Query("depth", "1"))
+	if err != nil || depth < 1 || depth > h.cfg.RelatedMaxDepth {
+		return newError(ErrBadRequest, "depth must be between 1 and "+strconv.Itoa(h.cfg.RelatedMaxDepth))
+	}
+
+	all, _ := h.store.List()
+	var origin models.Book
+	found := false
+	for _, b := range all {
+		if b.ID == id {
+			origin, found = b, true
+			break
+		}
+	}
+	if !found {
+		return newError(ErrNotFound, "book not found")
+	}
+	type scored struct {
+		book  models.Book
+		depth int
+		score int
+	}
+	seen := map[string]bool{origin.ID: true}
+	frontier := []models.Book{origin}
+	candidates := make([]scored, 0)
+	for level := 1; level <= depth && len(frontier) > 0; level++ {
+		var next []models.Book
+		for _, b := range all {
+			if seen[b.ID] {
+				continue
+			}
+			best := 0
+			for _, from := range frontier {
+				if score := relatedScore(from, b); score > best {
+					best = score
+				}
+			}
+			if best > 0 {
+				seen[b.ID] = true
+				candidates = append(candidates, scored{book: b, depth: level, score: best})
+				next = append(next, b)
+			}
+		}
+		frontier = next
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].depth != candidates[j].depth {
+			return candidates[i].depth < candidates[j].depth
+		}
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		if candidates[i].book.Title != candidates[j].book.Title {
+			return candidates[i].book.Title < candidates[j].book.Title
+		}
+		return candidates[i].book.ID < candidates[j].book.ID
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	related := make([]models.Book, len(candidates))
+	for i, cand := range candidates {
+		related[i] = cand.book
+	}
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"data": related})
+}
+
+// relatedScore measures how much two books have in common: a point for the
+// same author and one per shared tag. A score of zero means they are
+// unrelated.
+func relatedScore(a, b models.Book) int {
+	score := 0
+	if normalizeKey(a.Author) == normalizeKey(b.Author) {
+		score++
+	}
+	for _, tag := range a.Tags {
+		if hasTag(b, tag) {
+			score++
+		}
+	}
+	return score
+}
+
+// normalizeKey folds a title or author into the form used to compare them.
+func normalizeKey(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+const maxExistsKeys = 100
+
+type BookKey struct {
+	Title  string `json:"title"`
+	Author string `json:"author"`
+}
+
+type BooksExistRequest struct {
+	Keys []BookKey `json:"keys"`
+}
+
+type BookExistsResult struct {
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Exists bool   `json:"exists"`
+	ID     string `json:"id,omitempty"`
+}
+
+// booksExist godoc
+// @Summary Check which books already exist
+// @Description Looks up each title/author pair, compared case-insensitively with surrounding whitespace ignored
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param request body BooksExistRequest true "Title and author pairs"
+// @Success 200 {object} map[string][]BookExistsResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/exists [post]
+func (h *Handler) booksExist(c *fiber.Ctx) error {
+	var payload BooksExistRequest
+	if err := parseBody(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	if len(payload.Keys) == 0 {
+		return newError(ErrBadRequest, "keys is required")
+	}
+	if len(payload.Keys) > maxExistsKeys {
+		return newError(ErrBadRequest, "too many keys (max "+strconv.Itoa(maxExistsKeys)+")")
+	}
+
+	all, _ := h.store.List()
+	index := make(map[BookKey]string, len(all))
+	for _, b := range all {
+		index[dedupeKey(b.Title, b.Author)] = b.ID
+	}
+
+	results := make([]BookExistsResult, len(payload.Keys))
+	for i, k := range payload.Keys {
+		id, ok := index[dedupeKey(k.Title, k.Author)]
+		results[i] = BookExistsResult{Title: k.Title, Author: k.Author, Exists: ok, ID: id}
+	}
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"results": results})
+}
+
+// dedupeKey identifies a book by its normalized title and author, the pair
+// under which two records count as the same book.
+func dedupeKey(title, author string) BookKey {
+	return BookKey{Title: normalizeKey(title), Author: normalizeKey(author)}
+}
+
+// createBook godoc
+// @Summary Create a new book
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param book body models.Book true "Create book"
+// @Param force query bool false "Create the book even if one with the same title and author exists"
+// @Success 201 {object} BookWriteResponse
+// @Header 201 {string} Location "Path of the new book"
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ValidationErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/ [post]
+func (h *Handler) createBook(c *fiber.Ctx) error {
+	var payload models.Book
+	if err := parseBody(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	h.fillGeneratedTitle(&payload)
+	if err := models.ValidateBookPayload(&payload); err != nil {
+		return err
+	}
+
+	var created models.Book
+	err := h.store.Tx(func(tx store.Tx) error {
+		if !c.QueryBool("force") {
+			if other, ok := duplicate(tx, payload); ok {
+				return newError(ErrConflict,
+					fmt.Sprintf("book %q by %s already exists (id %s); use force=true to create it anyway", other.Title, other.Author, other.ID))
+			}
+		}
+		if err := h.checkUniqueTitle(tx, payload); err != nil {
+			return err
+		}
+		created = tx.Create(payload)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Location(path.Join(c.Path(), created.ID))
+	return h.sendJSON(c, http.StatusCreated, writeResponse(created))
+}
+
+// checkUniqueTitle enforces, when enabled, that no other book by the same
+// author has the same title, both compared in normalized form.
+func (h *Handler) checkUniqueTitle(tx store.Tx, b models.Book) error {
+	if !h.cfg.UniqueTitlePerAuthor {
+		return nil
+	}
+	if other, ok := duplicate(tx, b); ok {
+		return newError(ErrConflict,
+			fmt.Sprintf("author already has a book titled %q (id %s)", other.Title, other.ID))
+	}
+	return nil
+}
+
+// duplicate finds another book with the same normalized title and author
+// as b.
+func duplicate(tx store.Tx, b models.Book) (models.Book, bool) {
+	key := dedupeKey(b.Title, b.Author)
+	for _, other := range tx.List() {
+		if other.ID != b.ID && dedupeKey(other.Title, other.Author) == key {
+			return other, true
+		}
+	}
+	return models.Book{}, false
+}
+
+// maxBulkCreate caps how many books one bulk create may insert.
+const maxBulkCreate = 1000
+
+// bulkCreateBooks godoc
+// @Summary Create several books at once
+// @Description Validates every book first and inserts either all of them or none
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param books body []models.Book true "Books to create"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/bulk [post]
+func (h *Handler) bulkCreateBooks(c *fiber.Ctx) error {
+	var payload []models.Book
+	if err := parseBody(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	if len(payload) == 0 {
+		return newError(ErrBadRequest, "at least one book is required")
+	}
+	if len(payload) > maxBulkCreate {
+		return newError(ErrTooLarge, "too many books (max "+strconv.Itoa(maxBulkCreate)+")")
+	}
+	errs := make([]error, len(payload))
+	forEachRow(len(payload), h.cfg.ImportWorkers, func(i int) {
+		h.fillGeneratedTitle(&payload[i])
+		errs[i] = models.ValidateBookPayload(&payload[i])
+	})
+	for i, err := range errs {
+		if err != nil {
+			return newError(ErrBadRequest, fmt.Sprintf("book %d: %v", i, err))
+		}
+	}
+
+	created := make([]BookWriteResponse, len(payload))
+	err := h.store.Tx(func(tx store.Tx) error {
+		if h.cfg.UniqueTitlePerAuthor {
+			seen := make(map[BookKey]int, len(payload))
+			for i, b := range payload {
+				if err := h.checkUniqueTitle(tx, b); err != nil {
+					return newError(ErrConflict, fmt.Sprintf("book %d: %s", i, err.Error()))
+				}
+				key := dedupeKey(b.Title, b.Author)
+				if j, dup := seen[key]; dup {
+					return newError(ErrConflict, fmt.Sprintf("book %d: same title and author as book %d", i, j))
+				}
+				seen[key] = i
+			}
+		}
+		for i := range payload {
+			created[i] = writeResponse(tx.Create(payload[i]))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return h.sendJSON(c, http.StatusCreated, fiber.Map{"data": created, "count": len(created)})
+}
+
+// updateBook godoc
+// @Summary Partially update a book
+// @Description JSON merge patch: fields left out are unchanged and optional fields sent as null are cleared. Title and author cannot be null or blank. With Content-Type application/json-patch+json the body is instead a JSON Patch (RFC 6902) of add, replace, remove and test operations; a failed test answers 409.
+// @Tags books
+// @Accept json,application/msgpack,application/json-patch+json
+// @Produce json,application/msgpack,application/xml
+// @Param id path string true "Book ID"
+// @Param book body models.Book true "Update book"
+// @Param If-Match header string false "ETag the change is based on"
+// @Success 200 {object} BookWriteResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/{id} [patch]
+func (h *Handler) updateBook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, ok := h.store.Get(id); !ok {
+		return newError(ErrNotFound, "book not found")
+	}
+
+	// A JSON Patch is applied as is; anything else is a merge patch.
+	var (
+		jsonPatch jsonpatch.Patch
+		payload   models.BookPatch
+	)
+	if isJSONPatch(c) {
+		var err error
+		if jsonPatch, err = parseJSONPatch(c); err != nil {
+			return err
+		}
+	} else {
+		if err := parsePatch(c, &payload); err != nil {
+			return newError(ErrBadRequest, "invalid request body")
+		}
+		if err := trimPatch(&payload); err != nil {
+			return newError(ErrValidation, err.Error())
+		}
+	}
+
+	var updated models.Book
+	err := h.store.Tx(func(tx store.Tx) error {
+		existing, ok := tx.Get(id)
+		if !ok {
+			return newError(ErrNotFound, "book not found")
+		}
+		if err := checkIfMatch(c, existing); err != nil {
+			return err
+		}
+		if jsonPatch != nil {
+			patched, err := applyJSONPatch(existing, jsonPatch)
+			if err != nil {
+				return err
+			}
+			existing = patched
+		} else {
+			applyPatch(&existing, payload)
+		}
+		if err := models.ValidateBookPayload(&existing); err != nil {
+			return err
+		}
+		if err := h.checkUniqueTitle(tx, existing); err != nil {
+			return err
+		}
+		updated = tx.Save(existing)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderETag, bookETag(updated))
+	return h.sendJSON(c, http.StatusOK, writeResponse(updated))
+}
+
+// trimPatch trims the required text fields of a patch before it is applied.
+// Title and author cannot be cleared, so sending either as null or blank is
+// an error rather than being treated as omitted.
+func trimPatch(patch *models.BookPatch) error {
+	if patch.Title.Set {
+		if patch.Title.Value = strings.TrimSpace(patch.Title.Value); patch.Title.Null || patch.Title.Value == "" {
+			return errors.New("title must not be blank")
+		}
+	}
+	if patch.Author.Set {
+		if patch.Author.Value = strings.TrimSpace(patch.Author.Value); patch.Author.Null || patch.Author.Value == "" {
+			return errors.New("author must not be blank")
+		}
+	}
+	return nil
+}
+
+// applyPatch copies the fields present in patch onto b. A field sent as null
+// is reset to its zero value, which the optional fields treat as unset.
+func applyPatch(b *models.Book, patch models.BookPatch) {
+	if patch.Title.Set {
+		b.Title = patch.Title.Value
+		b.GeneratedTitle = false
+	}
+	if patch.Author.Set {
+		b.Author = patch.Author.Value
+	}
+	if patch.Year.Set {
+		b.Year = patch.Year.Value
+	}
+	if patch.Copies.Set {
+		b.Copies = patch.Copies.Value
+	}
+	if patch.Language.Set {
+		b.Language = patch.Language.Value
+	}
+	if patch.ISBN.Set {
+		b.ISBN = patch.ISBN.Value
+	}
+	if patch.Tags.Set {
+		b.Tags = patch.Tags.Value
+	}
+	if patch.PublishedCity.Set {
+		b.PublishedCity = patch.PublishedCity.Value
+	}
+	if patch.Latitude.Set {
+		b.Latitude = optionalPtr(patch.Latitude)
+	}
+	if patch.Longitude.Set {
+		b.Longitude = optionalPtr(patch.Longitude)
+	}
+}
+
+func optionalPtr[T any](o models.Optional[T]) *T {
+	if o.Null {
+		return nil
+	}
+	v := o.Value
+	return &v
+}
+
+const maxBulkIDs = 100
+
+type BulkPatchRequest struct {
+	IDs     []string         `json:"ids"`
+	Changes models.BookPatch `json:"changes" swaggertype:"object"`
+}
+
+type BulkPatchResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status" example:"updated"`
+	Error  string `json:"error,omitempty"`
+	// Errors lists the fields that failed validation for an invalid book.
+	Errors []models.FieldError `json:"errors,omitempty"`
+}
+
+// bulkUpdateBooks godoc
+// @Summary Partially update several books by ID
+// @Description Applies the same partial update to each listed book and reports the outcome per ID
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param request body BulkPatchRequest true "IDs and changes"
+// @Success 200 {object} map[string][]BulkPatchResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/bulk [patch]
+func (h *Handler) bulkUpdateBooks(c *fiber.Ctx) error {
+	var payload BulkPatchRequest
+	if err := parsePatch(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	if len(payload.IDs) == 0 {
+		return newError(ErrBadRequest, "ids is required")
+	}
+	if len(payload.IDs) > maxBulkIDs {
+		return newError(ErrBadRequest, "too many ids (max "+strconv.Itoa(maxBulkIDs)+")")
+	}
+	if err := trimPatch(&payload.Changes); err != nil {
+		return newError(ErrValidation, err.Error())
+	}
+
+	results := make([]BulkPatchResult, 0, len(payload.IDs))
+	err := h.store.Tx(func(tx store.Tx) error {
+		for _, id := range payload.IDs {
+			existing, ok := tx.Get(id)
+			if !ok {
+				results = append(results, BulkPatchResult{ID: id, Status: "not_found"})
+				continue
+			}
+			applyPatch(&existing, payload.Changes)
+			if err := models.ValidateBookPayload(&existing); err != nil {
+				result := BulkPatchResult{ID: id, Status: "invalid", Error: err.Error()}
+				var verr *models.ValidationError
+				if errors.As(err, &verr) {
+					result.Errors = verr.Fields
+				}
+				results = append(results, result)
+				continue
+			}
+			if err := h.checkUniqueTitle(tx, existing); err != nil {
+				results = append(results, BulkPatchResult{ID: id, Status: "conflict", Error: err.Error()})
+				continue
+			}
+			tx.Save(existing)
+			results = append(results, BulkPatchResult{ID: id, Status: "updated"})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"results": results})
+}
+
+// BookFilter selects the books a filtered update applies to. Fields left
+// out match every book.
+type BookFilter struct {
+	Author   string   `json:"author" example:"Martin Fowler"`
+	Language string   `json:"language" example:"en"`
+	Tags     []string `json:"tags"`
+	YearMin  int      `json:"year_min"`
+	YearMax  int      `json:"year_max"`
+}
+
+type FilterPatchRequest struct {
+	Filter BookFilter       `json:"filter"`
+	Update models.BookPatch `json:"update" swaggertype:"object"`
+}
+
+type FilterPatchResult struct {
+	// Matched counts the books the filter selected; Modified those of them
+	// the update changed.
+	Matched  int `json:"matched"`
+	Modified int `json:"modified"`
+}
+
+// updateBooksByFilter godoc
+// @Summary Partially update every book matching a filter
+// @Description Applies a merge patch, like PATCH /books/{id}, to every book the filter matches. Either all matching books are updated or, if any would become invalid or conflict, none is. An empty filter is refused unless all=true.
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param request body FilterPatchRequest true "Filter and update"
+// @Param all query bool false "Allow an empty filter, updating every book"
+// @Success 200 {object} FilterPatchResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/ [patch]
+func (h *Handler) updateBooksByFilter(c *fiber.Ctx) error {
+	var payload FilterPatchRequest
+	if err := parsePatch(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	if payload.Update.IsEmpty() {
+		return newError(ErrBadRequest, "update is required")
+	}
+	if err := trimPatch(&payload.Update); err != nil {
+		return newError(ErrValidation, err.Error())
+	}
+	filter := bookFilter{
+		author:   normalizeKey(payload.Filter.Author),
+		language: normalizeKey(payload.Filter.Language),
+		yearMin:  payload.Filter.YearMin,
+		yearMax:  payload.Filter.YearMax,
+	}
+	for _, tag := range payload.Filter.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filter.tags = append(filter.tags, tag)
+		}
+	}
+	if filter.author == "" && filter.language == "" && len(filter.tags) == 0 &&
+		filter.yearMin == 0 && filter.yearMax == 0 && !c.QueryBool("all") {
+		return newError(ErrBadRequest, "filter is empty; pass all=true to update every book")
+	}
+
+	var result FilterPatchResult
+	err := h.store.Tx(func(tx store.Tx) error {
+		// Check every book before saving any, so a failure changes nothing.
+		var changed []models.Book
+		seen := make(map[BookKey]string)
+		for _, existing := range tx.List() {
+			if !filter.matches(existing) {
+				continue
+			}
+			result.Matched++
+			updated := existing
+			applyPatch(&updated, payload.Update)
+			if err := models.ValidateBookPayload(&updated); err != nil {
+				return fmt.Errorf("book %s: %w", existing.ID, err)
+			}
+			if bookETag(updated) == bookETag(existing) {
+				continue
+			}
+			if h.cfg.UniqueTitlePerAuthor {
+				if err := h.checkUniqueTitle(tx, updated); err != nil {
+					return newError(ErrConflict, fmt.Sprintf("book %s: %v", existing.ID, err))
+				}
+				key := dedupeKey(updated.Title, updated.Author)
+				if other, dup := seen[key]; dup {
+					return newError(ErrConflict, fmt.Sprintf("book %s: same title and author as book %s", existing.ID, other))
+				}
+				seen[key] = existing.ID
+			}
+			changed = append(changed, updated)
+		}
+		for _, b := range changed {
+			tx.Save(b)
+		}
+		result.Modified = len(changed)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return h.sendJSON(c, http.StatusOK, result)
+}
+
+// replaceBook godoc
+// @Summary Replace a book (PUT)
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param id path string true "Book ID"
+// @Param book body models.Book true "Replace book"
+// @Param If-Match header string false "ETag the change is based on"
+// @Success 200 {object} BookWriteResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ValidationErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/{id} [put]
+func (h *Handler) replaceBook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var payload models.Book
+	if err := parseBody(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	h.fillGeneratedTitle(&payload)
+	if err := models.ValidateBookPayload(&payload); err != nil {
+		return err
+	}
+	payload.ID = id
+
+	var replaced models.Book
+	err := h.store.Tx(func(tx store.Tx) error {
+		existing, exists := tx.Get(id)
+		if !exists {
+			return newError(ErrNotFound, "book not found")
+		}
+		if err := checkIfMatch(c, existing); err != nil {
+			return err
+		}
+		if err := h.checkUniqueTitle(tx, payload); err != nil {
+			return err
+		}
+		replaced = tx.Save(payload)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderETag, bookETag(replaced))
+	return h.sendJSON(c, http.StatusOK, writeResponse(replaced))
+}
+
+type CopiesAdjustment struct {
+	Delta int `json:"delta" example:"-1"`
+}
+
+// adjustCopies godoc
+// @Summary Atomically adjust the number of copies of a book
+// @Description Adds delta to the copy count, refusing to go below zero
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param id path string true "Book ID"
+// @Param adjustment body CopiesAdjustment true "Change in copies"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/{id}/copies:adjust [post]
+func (h *Handler) adjustCopies(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var payload CopiesAdjustment
+	if err := parseBody(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	if payload.Delta == 0 {
+		return newError(ErrBadRequest, "delta must be non-zero")
+	}
+
+	b, err := h.store.Update(id, func(b *models.Book) error {
+		if b.Copies+payload.Delta < 0 {
+			return newError(ErrConflict, "not enough copies")
+		}
+		b.Copies += payload.Delta
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return newError(ErrNotFound, "book not found")
+	}
+	if err != nil {
+		return err
+	}
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"id": b.ID, "copies": b.Copies})
+}
+
+// deleteBook godoc
+// @Summary Delete a book by ID
+// @Description Moves the book to the trash, from where it can be restored. With hard=true it is removed for good, even from the trash.
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param id path string true "Book ID"
+// @Param hard query bool false "Delete permanently instead of moving to the trash"
+// @Success 204 "No Content"
+// @Failure 404 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/{id} [delete]
+func (h *Handler) deleteBook(c *fiber.Ctx) error {
+	del := h.store.Delete
+	if c.QueryBool("hard") {
+		del = h.store.Purge
+	}
+	err := del(c.Params("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		return newError(ErrNotFound, "book not found")
+	}
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+type BatchDeleteRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type BatchDeleteResult struct {
+	Deleted  int      `json:"deleted"`
+	NotFound []string `json:"not_found"`
+}
+
+// batchDeleteBooks godoc
+// @Summary Delete several books by ID
+// @Description Moves every listed book to the trash in one step, or deletes them for good with hard=true. IDs that do not exist are reported, not treated as errors.
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param request body BatchDeleteRequest true "IDs to delete"
+// @Param hard query bool false "Delete permanently instead of moving to the trash"
+// @Success 200 {object} BatchDeleteResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/batch-delete [post]
+func (h *Handler) batchDeleteBooks(c *fiber.Ctx) error {
+	var payload BatchDeleteRequest
+	if err := parseBody(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	if len(payload.IDs) == 0 {
+		return newError(ErrBadRequest, "ids is required")
+	}
+	if len(payload.IDs) > maxBulkIDs {
+		return newError(ErrBadRequest, "too many ids (max "+strconv.Itoa(maxBulkIDs)+")")
+	}
+
+	hard := c.QueryBool("hard")
+	result := BatchDeleteResult{NotFound: []string{}}
+	err := h.store.Tx(func(tx store.Tx) error {
+		for _, id := range payload.IDs {
+			var deleted bool
+			if hard {
+				deleted = tx.Purge(id)
+			} else {
+				deleted = tx.Delete(id)
+			}
+			if deleted {
+				result.Deleted++
+			} else {
+				result.NotFound = append(result.NotFound, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return h.sendJSON(c, http.StatusOK, result)
+}
+
+// getTrash godoc
+// @Summary List deleted books
+// @Description Books in the trash, most recently deleted first
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Success 200 {object} map[string][]models.Book
+// @Router /books/trash [get]
+func (h *Handler) getTrash(c *fiber.Ctx) error {
+	books := h.store.Trash()
+	sort.Slice(books, func(i, j int) bool {
+		if !books[i].DeletedAt.Equal(*books[j].DeletedAt) {
+			return books[i].DeletedAt.After(*books[j].DeletedAt)
+		}
+		return books[i].ID < books[j].ID
+	})
+	return h.sendJSON(c, http.StatusOK, fiber.Map{"data": books})
+}
+
+// restoreBook godoc
+// @Summary Restore a deleted book
+// @Tags books
+// @Produce json,application/msgpack,application/xml
+// @Param id path string true "Book ID"
+// @Success 200 {object} BookWriteResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/{id}/restore [post]
+func (h *Handler) restoreBook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var restored models.Book
+	err := h.store.Tx(func(tx store.Tx) error {
+		b, ok := tx.Trashed(id)
+		if !ok {
+			return newError(ErrNotFound, "book not found in trash")
+		}
+		// Another book may have taken the title while this one was deleted.
+		if err := h.checkUniqueTitle(tx, b); err != nil {
+			return err
+		}
+		restored, _ = tx.Restore(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderETag, bookETag(restored))
+	return h.sendJSON(c, http.StatusOK, writeResponse(restored))
+}
+
+type Operation struct {
+	Method      string `json:"method"`
+	Description string `json:"description"`
+}
+
+type ResourceCapabilities struct {
+	Operations   []Operation `json:"operations"`
+	QueryParams  []string    `json:"query_params"`
+	ContentTypes []string    `json:"content_types"`
+}
+
+// listQueryParams are the query parameters getAllBooks understands.
+var listQueryParams = []string{"page", "limit", "cursor", "sort", "author", "language", "tag", "withoutTag", "untagged", "idsOnly", "embed", "sinceVersion", "year_min", "year_max"}
+
+var (
+	collectionCapabilities = ResourceCapabilities{
+		Operations: []Operation{
+			{Method: http.MethodGet, Description: "List books"},
+			{Method: http.MethodHead, Description: "List books without a body"},
+			{Method: http.MethodPost, Description: "Create a book"},
+			{Method: http.MethodPatch, Description: "Partially update the books matching a filter"},
+			{Method: http.MethodOptions, Description: "Describe this resource"},
+		},
+		QueryParams:  listQueryParams,
+		ContentTypes: []string{fiber.MIMEApplicationJSON, mimeApplicationMsgpack},
+	}
+	itemCapabilities = ResourceCapabilities{
+		Operations: []Operation{
+			{Method: http.MethodGet, Description: "Get a book"},
+			{Method: http.MethodHead, Description: "Get a book without a body"},
+			{Method: http.MethodPut, Description: "Replace a book"},
+			{Method: http.MethodPatch, Description: "Partially update a book"},
+			{Method: http.MethodDelete, Description: "Move a book to the trash, or delete it for good with hard=true"},
+			{Method: http.MethodOptions, Description: "Describe this resource"},
+		},
+		QueryParams:  []string{},
+		ContentTypes: []string{fiber.MIMEApplicationJSON, mimeApplicationMsgpack, mimeApplicationJSONPatch},
+	}
+)
+
+// optionsHandler answers OPTIONS requests with the Allow header and a
+// description of what the resource supports.
+func (h *Handler) optionsHandler(caps ResourceCapabilities) fiber.Handler {
+	methods := make([]string, 0, len(caps.Operations))
+	enabled := make([]Operation, 0, len(caps.Operations))
+	for _, op := range caps.Operations {
+		if !h.methodDisabled(op.Method) {
+			methods = append(methods, op.Method)
+			enabled = append(enabled, op)
+		}
+	}
+	caps.Operations = enabled
+	allow := strings.Join(methods, ", ")
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderAllow, allow)
+		return h.sendJSON(c, http.StatusOK, caps)
+	}
+}
@@ -0,0 +1,2318 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"demo-golang/config"
+	"demo-golang/models"
+	"demo-golang/store"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// newTestApp returns an app serving the book routes from a fresh in-memory
+// store, so no test sees books created by another.
+func newTestApp(t *testing.T) (*fiber.App, *store.Store) {
+	t.Helper()
+	return newTestAppWithConfig(t, config.Default())
+}
+
+// newTestAppWithConfig is newTestApp with the book routes shaped by cfg.
+func newTestAppWithConfig(t *testing.T, cfg config.Config) (*fiber.App, *store.Store) {
+	t.Helper()
+	s := store.New("", 1)
+	h := New(s, cfg)
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler, BodyLimit: cfg.BodyLimit})
+	h.Register(app.Group("/api").Group("/books"))
+	return app, s
+}
+
+// do sends a request with an optional JSON body and returns the response
+// status and body.
+func do(t *testing.T, app *fiber.App, method, target, body string) (int, []byte) {
+	t.Helper()
+	return doWithKey(t, app, method, target, body, "")
+}
+
+// doWithKey is do with apiKey in the X-API-Key header, unless it is empty.
+func doWithKey(t *testing.T, app *fiber.App, method, target, body, apiKey string) (int, []byte) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading body: %v", method, target, err)
+	}
+	return resp.StatusCode, data
+}
+
+func decode(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+}
+
+func seed(t *testing.T, s *store.Store, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		b := models.Book{Title: fmt.Sprintf("Book %02d", i), Author: "Author"}
+		if _, err := s.Create(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateBook(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/books/", strings.NewReader(`{"title":"Clean Code","author":"Robert C. Martin","year":2008}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	header := resp.Header
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusCreated, body)
+	}
+	var created models.Book
+	decode(t, body, &created)
+	if _, err := uuid.Parse(created.ID); err != nil {
+		t.Errorf("id %q is not a UUID: %v", created.ID, err)
+	}
+	if got, want := header.Get(fiber.HeaderLocation), "/api/books/"+created.ID; got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	if got := header.Get(fiber.HeaderContentType); got != fiber.MIMEApplicationJSON {
+		t.Errorf("Content-Type = %q, want %q", got, fiber.MIMEApplicationJSON)
+	}
+	if created.Title != "Clean Code" || created.Author != "Robert C. Martin" || created.Year != 2008 {
+		t.Errorf("created = %+v", created)
+	}
+}
+
+func TestCreateBookRejectsInvalid(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"author":"A"}`, http.StatusUnprocessableEntity},
+		{`{"title":"T"}`, http.StatusUnprocessableEntity},
+		{`{"title":`, http.StatusBadRequest},
+		{`{"title":"T","author":"A","year":50000}`, http.StatusUnprocessableEntity},
+		{`{"title":"T","author":"A","year":-3}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if status, resp := do(t, app, http.MethodPost, "/api/books/", tt.body); status != tt.want {
+			t.Errorf("POST %s: status = %d, want %d: %s", tt.body, status, tt.want, resp)
+		}
+	}
+}
+
+func TestCreateBookReportsEveryInvalidField(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":" ","copies":-1,"latitude":91,"isbn":"123"}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusUnprocessableEntity, body)
+	}
+	var got ValidationErrorResponse
+	decode(t, body, &got)
+	var fields []string
+	for _, f := range got.Errors {
+		if f.Message == "" {
+			t.Errorf("field %s has no message", f.Field)
+		}
+		fields = append(fields, f.Field)
+	}
+	if want := "title author copies longitude latitude isbn"; strings.Join(fields, " ") != want {
+		t.Errorf("fields = %v, want %s", fields, want)
+	}
+}
+
+func TestGetBook(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := do(t, app, http.MethodGet, "/api/books/"+b.ID, "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var got models.Book
+	decode(t, body, &got)
+	if got.ID != b.ID || got.Title != b.Title {
+		t.Errorf("got %+v, want %+v", got, b)
+	}
+
+	status, body = do(t, app, http.MethodGet, "/api/books/"+uuid.NewString(), "")
+	if status != http.StatusNotFound {
+		t.Errorf("missing book: status = %d, want %d", status, http.StatusNotFound)
+	}
+	var errResp ErrorResponse
+	decode(t, body, &errResp)
+	if errResp.Error != "book not found" {
+		t.Errorf("missing book: error = %q, want %q", errResp.Error, "book not found")
+	}
+}
+
+func TestUpdateBookChangesOnlyGivenFields(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999, Copies: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := do(t, app, http.MethodPatch, "/api/books/"+b.ID, `{"year":2018}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	got, _ := s.Get(b.ID)
+	if got.Year != 2018 {
+		t.Errorf("year = %d, want 2018", got.Year)
+	}
+	if got.Title != b.Title || got.Author != b.Author || got.Copies != b.Copies {
+		t.Errorf("untouched fields changed: got %+v, had %+v", got, b)
+	}
+}
+
+func TestReplaceMissingBook(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	status, body := do(t, app, http.MethodPut, "/api/books/"+uuid.NewString(), `{"title":"T","author":"A"}`)
+	if status != http.StatusNotFound {
+		t.Errorf("status = %d, want %d: %s", status, http.StatusNotFound, body)
+	}
+}
+
+func TestDeleteBook(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := do(t, app, http.MethodDelete, "/api/books/"+b.ID, ""); status != http.StatusNoContent {
+		t.Fatalf("first delete: status = %d, want %d: %s", status, http.StatusNoContent, body)
+	}
+	if status, _ := do(t, app, http.MethodDelete, "/api/books/"+b.ID, ""); status != http.StatusNotFound {
+		t.Errorf("second delete: status = %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := do(t, app, http.MethodGet, "/api/books/"+b.ID, ""); status != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantPage  int
+		wantLimit int
+		wantLen   int
+	}{
+		{"defaults", "", 1, 50, 12},
+		{"middle page", "?page=2&limit=5", 2, 5, 5},
+		{"partial last page", "?page=3&limit=5", 3, 5, 2},
+		{"page beyond end", "?page=10&limit=5", 10, 5, 0},
+		{"limit of zero falls back to default", "?limit=0", 1, 50, 12},
+		{"negative limit falls back to default", "?limit=-3", 1, 50, 12},
+		{"page zero is the first page", "?page=0&limit=5", 1, 5, 5},
+		{"limit above the max is capped", "?limit=1000", 1, 200, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, s := newTestApp(t)
+			seed(t, s, 12)
+
+			status, body := do(t, app, http.MethodGet, "/api/books/"+tt.query, "")
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+			}
+			var got struct {
+				Data       []models.Book `json:"data"`
+				Page       int           `json:"page"`
+				Limit      int           `json:"limit"`
+				Total      int           `json:"total"`
+				TotalPages int           `json:"total_pages"`
+			}
+			decode(t, body, &got)
+			if got.Page != tt.wantPage || got.Limit != tt.wantLimit || len(got.Data) != tt.wantLen || got.Total != 12 {
+				t.Errorf("page=%d limit=%d len=%d total=%d, want page=%d limit=%d len=%d total=12",
+					got.Page, got.Limit, len(got.Data), got.Total, tt.wantPage, tt.wantLimit, tt.wantLen)
+			}
+			if want := (12 + tt.wantLimit - 1) / tt.wantLimit; got.TotalPages != want {
+				t.Errorf("total_pages = %d, want %d", got.TotalPages, want)
+			}
+		})
+	}
+}
+
+func TestListPageBeyondEndIsEmptyArray(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 3)
+
+	status, body := do(t, app, http.MethodGet, "/api/books/?page=5", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	if !strings.Contains(string(body), `"data":[]`) {
+		t.Errorf("body = %s, want an empty data array", body)
+	}
+}
+
+func TestEmptyResultsAreArrays(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	for _, target := range []string{
+		"/api/books/",
+		"/api/books/?language=en&year_min=1990",
+		"/api/books/?idsOnly=true",
+		"/api/books/search?q=go",
+		"/api/books/sample",
+		"/api/books/top-authors",
+	} {
+		status, body := do(t, app, http.MethodGet, target, "")
+		if status != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d: %s", target, status, http.StatusOK, body)
+			continue
+		}
+		if !strings.Contains(string(body), `"data":[]`) {
+			t.Errorf("GET %s: body = %s, want an empty data array", target, body)
+		}
+	}
+}
+
+func TestListRejectsNonNumericPaging(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	for _, query := range []string{"?page=abc", "?limit=ten", "?page=1.5"} {
+		if status, body := do(t, app, http.MethodGet, "/api/books/"+query, ""); status != http.StatusBadRequest {
+			t.Errorf("GET %s: status = %d, want %d: %s", query, status, http.StatusBadRequest, body)
+		}
+	}
+}
+
+func TestListCustomEnvelopeKeys(t *testing.T) {
+	cfg := config.Default()
+	cfg.Envelope.Data = "results"
+	cfg.Envelope.Total = "count"
+	cfg.Envelope.Page = "pageNumber"
+	app, s := newTestAppWithConfig(t, cfg)
+	seed(t, s, 3)
+
+	status, body := do(t, app, http.MethodGet, "/api/books/?page=2&limit=2", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var page map[string]json.RawMessage
+	decode(t, body, &page)
+	for _, key := range []string{"data", "total", "page"} {
+		if _, ok := page[key]; ok {
+			t.Errorf("response still has default key %q: %s", key, body)
+		}
+	}
+	var got struct {
+		Results    []models.Book `json:"results"`
+		Count      int           `json:"count"`
+		PageNumber int           `json:"pageNumber"`
+		Limit      int           `json:"limit"`
+	}
+	decode(t, body, &got)
+	if len(got.Results) != 1 || got.Count != 3 || got.PageNumber != 2 || got.Limit != 2 {
+		t.Errorf("results=%d count=%d pageNumber=%d limit=%d, want 1, 3, 2, 2", len(got.Results), got.Count, got.PageNumber, got.Limit)
+	}
+}
+
+func TestListBooksLinkHeader(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 5)
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/api/books/?limit=2&language=", `</api/books/?language=&limit=2&page=1>; rel="first", </api/books/?language=&limit=2&page=2>; rel="next", </api/books/?language=&limit=2&page=3>; rel="last"`},
+		{"/api/books/?limit=2&page=2", `</api/books/?limit=2&page=1>; rel="first", </api/books/?limit=2&page=1>; rel="prev", </api/books/?limit=2&page=3>; rel="next", </api/books/?limit=2&page=3>; rel="last"`},
+		{"/api/books/?limit=2&page=3", `</api/books/?limit=2&page=1>; rel="first", </api/books/?limit=2&page=2>; rel="prev", </api/books/?limit=2&page=3>; rel="last"`},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get(fiber.HeaderLink); got != tt.want {
+			t.Errorf("GET %s: Link = %s, want %s", tt.target, got, tt.want)
+		}
+		if got := resp.Header.Get(headerTotalCount); got != "5" {
+			t.Errorf("GET %s: X-Total-Count = %q, want 5", tt.target, got)
+		}
+	}
+}
+
+func TestListBooksByCursor(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 5)
+
+	type cursorPage struct {
+		Data       []models.Book `json:"data"`
+		NextCursor *string       `json:"next_cursor"`
+	}
+	var seen []string
+	target := "/api/books/?limit=2&cursor="
+	for i := 0; ; i++ {
+		status, body := do(t, app, http.MethodGet, target, "")
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want %d: %s", target, status, http.StatusOK, body)
+		}
+		var page cursorPage
+		decode(t, body, &page)
+		for _, b := range page.Data {
+			seen = append(seen, b.ID)
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		if i == 0 {
+			// A book added before the cursor must not shift later pages.
+			if _, err := s.Create(models.Book{Title: "Late", Author: "Author"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		target = "/api/books/?limit=2&cursor=" + *page.NextCursor
+	}
+
+	all, _ := s.List()
+	if len(seen) < 5 || len(seen) > len(all) {
+		t.Fatalf("saw %d books, want between 5 and %d", len(seen), len(all))
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i-1] >= seen[i] {
+			t.Fatalf("ids out of order or repeated: %v", seen)
+		}
+	}
+}
+
+func TestListBooksRejectsBadCursor(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	for _, target := range []string{"/api/books/?cursor=%21%21", "/api/books/?cursor=&page=2", "/api/books/?cursor=e30.e30"} {
+		if status, body := do(t, app, http.MethodGet, target, ""); status != http.StatusBadRequest {
+			t.Errorf("GET %s: status = %d, want %d: %s", target, status, http.StatusBadRequest, body)
+		}
+	}
+}
+
+// nextCursor returns the next_cursor of the list page at target.
+func nextCursor(t *testing.T, app *fiber.App, target string) string {
+	t.Helper()
+	status, body := do(t, app, http.MethodGet, target, "")
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status = %d, want %d: %s", target, status, http.StatusOK, body)
+	}
+	var page struct {
+		NextCursor string `json:"next_cursor"`
+	}
+	decode(t, body, &page)
+	return page.NextCursor
+}
+
+func TestListBooksByCursorKeepsSortAndFilters(t *testing.T) {
+	app, s := newTestApp(t)
+	create(t, s,
+		models.Book{Title: "A", Author: "Author", Year: 2001, Tags: []string{"go"}},
+		models.Book{Title: "B", Author: "Author", Year: 2005, Tags: []string{"go"}},
+		models.Book{Title: "C", Author: "Author", Year: 2003, Tags: []string{"rust"}},
+		models.Book{Title: "D", Author: "Author", Year: 2004, Tags: []string{"go"}},
+		models.Book{Title: "E", Author: "Author", Year: 2002, Tags: []string{"go"}},
+		models.Book{Title: "F", Author: "Author", Year: 2006, Tags: []string{"go"}},
+	)
+
+	want := titles(t, app, "/api/books/?tag=go&year_max=2005&sort=-year")
+	if !slices.Equal(want, []string{"B", "D", "E", "A"}) {
+		t.Fatalf("unpaged titles = %v", want)
+	}
+	var got []string
+	target := "/api/books/?cursor=&limit=1&tag=go&year_max=2005&sort=-year"
+	for cursor := "start"; cursor != ""; {
+		got = append(got, titles(t, app, target)...)
+		cursor = nextCursor(t, app, target)
+		// Later pages send only the cursor and the page size.
+		target = "/api/books/?limit=1&cursor=" + url.QueryEscape(cursor)
+		if len(got) > len(want) {
+			t.Fatalf("cursor pagination does not end: %v", got)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("titles by cursor = %v, want %v", got, want)
+	}
+}
+
+func TestListBooksCursorIsSigned(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 3)
+	cursor := nextCursor(t, app, "/api/books/?cursor=&limit=1&sort=title")
+	encoded, signature, _ := strings.Cut(cursor, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := base64.RawURLEncoding.EncodeToString(bytes.Replace(payload, []byte("sort=title"), []byte("sort=year"), 1))
+
+	tests := []struct {
+		name, query string
+		status      int
+	}{
+		{"same sort re-sent", "cursor=" + cursor + "&sort=title", http.StatusOK},
+		{"other sort re-sent", "cursor=" + cursor + "&sort=year", http.StatusBadRequest},
+		{"filter added", "cursor=" + cursor + "&author=Author", http.StatusBadRequest},
+		{"payload altered", "cursor=" + forged + "." + signature, http.StatusBadRequest},
+		{"signature altered", "cursor=" + encoded + "." + strings.Repeat("A", len(signature)), http.StatusBadRequest},
+		{"signature missing", "cursor=" + encoded, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if status, body := do(t, app, http.MethodGet, "/api/books/?"+tt.query, ""); status != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, status, tt.status, body)
+		}
+	}
+
+	// A cursor signed by another instance is rejected.
+	other, _ := newTestApp(t)
+	if status, body := do(t, other, http.MethodGet, "/api/books/?cursor="+cursor, ""); status != http.StatusBadRequest {
+		t.Errorf("foreign cursor: status = %d, want %d: %s", status, http.StatusBadRequest, body)
+	}
+}
+
+func TestListBooksByCursorInStrictMode(t *testing.T) {
+	cfg := config.Default()
+	cfg.StrictQuery = true
+	app, s := newTestAppWithConfig(t, cfg)
+	seed(t, s, 3)
+
+	target := "/api/books/?cursor=&limit=2"
+	for pages := 0; target != ""; pages++ {
+		status, body := do(t, app, http.MethodGet, target, "")
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want %d: %s", target, status, http.StatusOK, body)
+		}
+		var page struct {
+			NextCursor *string `json:"next_cursor"`
+		}
+		decode(t, body, &page)
+		target = ""
+		if page.NextCursor != nil {
+			target = "/api/books/?limit=2&cursor=" + *page.NextCursor
+		}
+		if pages > 2 {
+			t.Fatal("cursor pagination does not end")
+		}
+	}
+
+	status, body := do(t, app, http.MethodOptions, "/api/books/", "")
+	var caps ResourceCapabilities
+	decode(t, body, &caps)
+	if status != http.StatusOK || !slices.Contains(caps.QueryParams, "cursor") {
+		t.Errorf("OPTIONS: status = %d, query_params = %v, want cursor listed", status, caps.QueryParams)
+	}
+}
+
+func TestPageSlice(t *testing.T) {
+	books := make([]models.Book, 7)
+	tests := []struct {
+		page, limit, want int
+	}{
+		{1, 3, 3},
+		{3, 3, 1},
+		{4, 3, 0},
+		{100, 3, 0},
+		{1, 10, 7},
+		{math.MaxInt/4 + 1, 4, 0},
+		{math.MaxInt/4 + 2, 4, 0},
+	}
+	if got := pageSlice(nil, 1, 10); got == nil {
+		t.Error("pageSlice(nil) is nil, want an empty slice")
+	}
+	for _, tt := range tests {
+		if got := len(pageSlice(books, tt.page, tt.limit)); got != tt.want {
+			t.Errorf("pageSlice(7 books, page %d, limit %d) has %d books, want %d", tt.page, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateBookNullClearsField(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999, Language: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := do(t, app, http.MethodPatch, "/api/books/"+b.ID, `{"year":null}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	got, _ := s.Get(b.ID)
+	if got.Year != 0 {
+		t.Errorf("year = %d, want it cleared", got.Year)
+	}
+	if got.Language != "en" || got.Title != b.Title {
+		t.Errorf("omitted fields changed: got %+v, had %+v", got, b)
+	}
+}
+
+func TestUpdateBookRejectsClearingRequiredFields(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{`{"title":null}`, `{"title":"  "}`, `{"author":null}`, `{"author":""}`} {
+		if status, resp := do(t, app, http.MethodPatch, "/api/books/"+b.ID, body); status != http.StatusUnprocessableEntity {
+			t.Errorf("PATCH %s: status = %d, want %d: %s", body, status, http.StatusUnprocessableEntity, resp)
+		}
+	}
+	if got, _ := s.Get(b.ID); got.Title != b.Title || got.Author != b.Author {
+		t.Errorf("book changed: got %+v, had %+v", got, b)
+	}
+}
+
+func TestUpdateBookValidatesMergedBook(t *testing.T) {
+	app, s := newTestApp(t)
+	lat, lng := 51.5, -0.1
+	b := create(t, s, models.Book{Title: "Refactoring", Author: "Martin Fowler", Latitude: &lat, Longitude: &lng})[0]
+
+	// Each patch is valid on its own; it is the merged book that is not.
+	for body, field := range map[string]string{`{"latitude":null}`: "latitude", `{"longitude":null,"year":1999}`: "longitude"} {
+		status, resp := do(t, app, http.MethodPatch, "/api/books/"+b.ID, body)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("PATCH %s: status = %d, want %d: %s", body, status, http.StatusUnprocessableEntity, resp)
+			continue
+		}
+		var got ValidationErrorResponse
+		decode(t, resp, &got)
+		if got.Code != "validation_error" || len(got.Errors) != 1 || got.Errors[0].Field != field {
+			t.Errorf("PATCH %s: body = %s, want a validation_error listing %s", body, resp, field)
+		}
+	}
+	got, _ := s.Get(b.ID)
+	if got.Version != b.Version || got.Latitude == nil || got.Longitude == nil || got.Year != 0 {
+		t.Errorf("book changed: got %+v, had %+v", got, b)
+	}
+}
+
+func TestUpdateBookMsgpackNullClearsField(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := msgpack.Marshal(map[string]interface{}{"year": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/api/books/"+b.ID, bytes.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, mimeApplicationMsgpack)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got, _ := s.Get(b.ID); got.Year != 0 {
+		t.Errorf("year = %d, want it cleared", got.Year)
+	}
+}
+
+func TestHeadBook(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/books/"+b.ID, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get.Body.Close()
+	head, err := app.Test(httptest.NewRequest(http.MethodHead, "/api/books/"+b.ID, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(head.Body)
+	head.Body.Close()
+	if head.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Errorf("HEAD: status = %d with %d body bytes, want 200 and no body", head.StatusCode, len(body))
+	}
+	for _, name := range []string{fiber.HeaderETag, fiber.HeaderContentLength} {
+		if got, want := head.Header.Get(name), get.Header.Get(name); got != want || got == "" {
+			t.Errorf("HEAD %s = %q, want %q as for GET", name, got, want)
+		}
+	}
+
+	if status, _ := do(t, app, http.MethodHead, "/api/books/"+uuid.NewString(), ""); status != http.StatusNotFound {
+		t.Errorf("HEAD missing book: status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestTags(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Dune","author":"Frank Herbert","tags":[" SciFi ","classic","scifi"]}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", status, body)
+	}
+	var dune models.Book
+	decode(t, body, &dune)
+	if strings.Join(dune.Tags, ",") != "SciFi,classic" {
+		t.Errorf("tags = %q, want trimmed and deduplicated [SciFi classic]", dune.Tags)
+	}
+	if status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Neuromancer","author":"William Gibson","tags":["scifi"]}`); status != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", status, body)
+	}
+	if status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Blank","author":"Nobody","tags":["  "]}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("blank tag: status = %d, want %d: %s", status, http.StatusUnprocessableEntity, body)
+	}
+
+	var list struct {
+		Data []models.Book `json:"data"`
+	}
+	_, body = do(t, app, http.MethodGet, "/api/books/?tag=SCIFI", "")
+	decode(t, body, &list)
+	if len(list.Data) != 2 {
+		t.Errorf("?tag=SCIFI matched %d books, want 2", len(list.Data))
+	}
+	_, body = do(t, app, http.MethodGet, "/api/books/?tag=scifi&tag=classic", "")
+	decode(t, body, &list)
+	if len(list.Data) != 1 || list.Data[0].ID != dune.ID {
+		t.Errorf("?tag=scifi&tag=classic matched %v, want only Dune", list.Data)
+	}
+
+	var tags struct {
+		Data []TagCount `json:"data"`
+	}
+	_, body = do(t, app, http.MethodGet, "/api/books/tags", "")
+	decode(t, body, &tags)
+	want := []TagCount{{Tag: "SciFi", Count: 2}, {Tag: "classic", Count: 1}}
+	if fmt.Sprint(tags.Data) != fmt.Sprint(want) {
+		t.Errorf("tags = %v, want %v", tags.Data, want)
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := do(t, app, http.MethodDelete, "/api/books/"+b.ID, ""); status != http.StatusNoContent {
+		t.Fatalf("delete: status = %d: %s", status, body)
+	}
+	var list struct {
+		Data []models.Book `json:"data"`
+	}
+	_, body := do(t, app, http.MethodGet, "/api/books/", "")
+	decode(t, body, &list)
+	if len(list.Data) != 0 {
+		t.Errorf("list after delete has %d books, want 0", len(list.Data))
+	}
+	_, body = do(t, app, http.MethodGet, "/api/books/trash", "")
+	decode(t, body, &list)
+	if len(list.Data) != 1 || list.Data[0].ID != b.ID || list.Data[0].DeletedAt == nil {
+		t.Fatalf("trash = %+v, want the deleted book with deleted_at set", list.Data)
+	}
+
+	status, body := do(t, app, http.MethodPost, "/api/books/"+b.ID+"/restore", "")
+	if status != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", status, body)
+	}
+	got, ok := s.Get(b.ID)
+	if !ok || got.DeletedAt != nil || !got.CreatedAt.Equal(b.CreatedAt) || got.Seq != b.Seq {
+		t.Errorf("restored book = %+v, want the original back without deleted_at", got)
+	}
+	if status, _ := do(t, app, http.MethodPost, "/api/books/"+b.ID+"/restore", ""); status != http.StatusNotFound {
+		t.Errorf("restoring a live book: status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestHardDelete(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := do(t, app, http.MethodDelete, "/api/books/"+b.ID+"?hard=true", ""); status != http.StatusNoContent {
+		t.Fatalf("hard delete: status = %d: %s", status, body)
+	}
+	if trash := s.Trash(); len(trash) != 0 {
+		t.Errorf("trash has %d books after a hard delete, want 0", len(trash))
+	}
+	if status, _ := do(t, app, http.MethodPost, "/api/books/"+b.ID+"/restore", ""); status != http.StatusNotFound {
+		t.Errorf("restoring a purged book: status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestBatchDelete(t *testing.T) {
+	app, s := newTestApp(t)
+	a, _ := s.Create(models.Book{Title: "A", Author: "Author"})
+	b, _ := s.Create(models.Book{Title: "B", Author: "Author"})
+	c, _ := s.Create(models.Book{Title: "C", Author: "Author"})
+	missing := uuid.NewString()
+
+	status, body := do(t, app, http.MethodPost, "/api/books/batch-delete",
+		fmt.Sprintf(`{"ids":[%q,%q,%q]}`, a.ID, missing, b.ID))
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var got BatchDeleteResult
+	decode(t, body, &got)
+	if got.Deleted != 2 || len(got.NotFound) != 1 || got.NotFound[0] != missing {
+		t.Errorf("result = %+v, want 2 deleted and %s not found", got, missing)
+	}
+	if _, ok := s.Get(c.ID); !ok || s.Len() != 1 {
+		t.Errorf("store has %d books, want only C left", s.Len())
+	}
+
+	if status, _ := do(t, app, http.MethodPost, "/api/books/batch-delete", `{"ids":[]}`); status != http.StatusBadRequest {
+		t.Errorf("empty ids: status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestRandomBook(t *testing.T) {
+	app, s := newTestApp(t)
+
+	if status, body := do(t, app, http.MethodGet, "/api/books/random", ""); status != http.StatusNotFound {
+		t.Errorf("empty store: status = %d, want %d: %s", status, http.StatusNotFound, body)
+	}
+
+	seed(t, s, 3)
+	status, body := do(t, app, http.MethodGet, "/api/books/random", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var b models.Book
+	decode(t, body, &b)
+	if _, ok := s.Get(b.ID); !ok {
+		t.Errorf("random book %q is not in the store", b.ID)
+	}
+
+	status, body = do(t, app, http.MethodGet, "/api/books/random?count=5", "")
+	if status != http.StatusOK {
+		t.Fatalf("count=5: status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var got struct {
+		Data []models.Book `json:"data"`
+	}
+	decode(t, body, &got)
+	ids := make(map[string]bool)
+	for _, b := range got.Data {
+		ids[b.ID] = true
+	}
+	if len(got.Data) != 3 || len(ids) != 3 {
+		t.Errorf("count=5 of 3 books returned %d books, %d distinct; want all 3", len(got.Data), len(ids))
+	}
+
+	if status, _ := do(t, app, http.MethodGet, "/api/books/random?count=0", ""); status != http.StatusBadRequest {
+		t.Errorf("count=0: status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestBooksByAuthor(t *testing.T) {
+	app, s := newTestApp(t)
+	for _, b := range []models.Book{
+		{Title: "Refactoring", Author: "Martin Fowler"},
+		{Title: "Patterns of Enterprise Application Architecture", Author: "martin fowler "},
+		{Title: "Clean Code", Author: "Robert C. Martin"},
+	} {
+		if _, err := s.Create(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		author string
+		want   int
+	}{
+		{"Martin%20Fowler", 2},
+		{"MARTIN%20FOWLER", 2},
+		{"Martin", 0},
+	}
+	for _, tt := range tests {
+		status, body := do(t, app, http.MethodGet, "/api/books/by-author/"+tt.author, "")
+		if status != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.author, status, http.StatusOK, body)
+		}
+		var got struct {
+			Data  []models.Book `json:"data"`
+			Total int           `json:"total"`
+		}
+		decode(t, body, &got)
+		if got.Data == nil || len(got.Data) != tt.want || got.Total != tt.want {
+			t.Errorf("%s: got %d books (total %d), want %d", tt.author, len(got.Data), got.Total, tt.want)
+		}
+	}
+}
+
+func TestXMLResponses(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler", Tags: []string{"classic"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(target string) (*http.Response, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationXML)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if got := resp.Header.Get(fiber.HeaderContentType); got != fiber.MIMEApplicationXMLCharsetUTF8 {
+			t.Errorf("GET %s: Content-Type = %q, want %q", target, got, fiber.MIMEApplicationXMLCharsetUTF8)
+		}
+		return resp, body
+	}
+
+	_, body := get("/api/books/" + b.ID)
+	var book struct {
+		XMLName xml.Name
+		ID      string   `xml:"id"`
+		Title   string   `xml:"title"`
+		Tags    []string `xml:"tags>item"`
+	}
+	if err := xml.Unmarshal(body, &book); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	if book.XMLName.Local != "book" || book.ID != b.ID || book.Title != b.Title || len(book.Tags) != 1 {
+		t.Errorf("got %+v from %s", book, body)
+	}
+
+	_, body = get("/api/books/")
+	var list struct {
+		Total int `xml:"total"`
+		Data  []struct {
+			ID string `xml:"id"`
+		} `xml:"data>item"`
+	}
+	if err := xml.Unmarshal(body, &list); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	if list.Total != 1 || len(list.Data) != 1 || list.Data[0].ID != b.ID {
+		t.Errorf("got %+v from %s", list, body)
+	}
+
+	resp, body := get("/api/books/" + uuid.NewString())
+	var errResp struct {
+		XMLName xml.Name
+		Error   string `xml:"error"`
+	}
+	if err := xml.Unmarshal(body, &errResp); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	if resp.StatusCode != http.StatusNotFound || errResp.XMLName.Local != "error" || errResp.Error != "book not found" {
+		t.Errorf("missing book: status %d, body %s", resp.StatusCode, body)
+	}
+}
+
+func TestBookViews(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 3)
+	all, _ := s.List()
+	popular := all[1].ID
+
+	var etags []string
+	for want := int64(1); want <= 3; want++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/books/"+popular, nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var b models.Book
+		decode(t, body, &b)
+		if b.Views != want {
+			t.Errorf("read %d: views = %d, want %d", want, b.Views, want)
+		}
+		etags = append(etags, resp.Header.Get(fiber.HeaderETag))
+	}
+	if etags[0] != etags[2] {
+		t.Errorf("ETag changed between reads: %q, %q", etags[0], etags[2])
+	}
+
+	status, body := do(t, app, http.MethodGet, "/api/books/?sort=-views", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var page struct {
+		Data []models.Book `json:"data"`
+	}
+	decode(t, body, &page)
+	if len(page.Data) != 3 || page.Data[0].ID != popular || page.Data[0].Views != 3 {
+		t.Errorf("sort=-views: first book %+v, want %s with 3 views", page.Data[0], popular)
+	}
+
+	if status, body := do(t, app, http.MethodDelete, "/api/books/"+popular, ""); status != http.StatusNoContent {
+		t.Fatalf("delete: status = %d: %s", status, body)
+	}
+	if status, body := do(t, app, http.MethodPost, "/api/books/"+popular+"/restore", ""); status != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", status, body)
+	}
+	if b, _ := s.Get(popular); b.Views != 0 {
+		t.Errorf("views = %d after delete and restore, want 0", b.Views)
+	}
+}
+
+func TestStats(t *testing.T) {
+	app, s := newTestApp(t)
+
+	status, body := do(t, app, http.MethodGet, "/api/books/stats", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	if want := `{"total":0,"with_year":0,"oldest_year":null,"newest_year":null,"authors":0,"top_author":null}`; string(body) != want {
+		t.Errorf("empty catalog: got %s, want %s", body, want)
+	}
+
+	for _, b := range []models.Book{
+		{Title: "Refactoring", Author: "Martin Fowler", Year: 1999},
+		{Title: "UML Distilled", Author: "martin fowler"},
+		{Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+		{Title: "The Pragmatic Programmer", Author: "Andy Hunt", Year: 1999},
+	} {
+		if _, err := s.Create(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, body = do(t, app, http.MethodGet, "/api/books/stats", "")
+	var got CatalogStats
+	decode(t, body, &got)
+	if got.Total != 4 || got.WithYear != 3 || got.Authors != 3 {
+		t.Errorf("got %s, want 4 books, 3 with a year, 3 authors", body)
+	}
+	if got.OldestYear == nil || *got.OldestYear != 1999 || got.NewestYear == nil || *got.NewestYear != 2008 {
+		t.Errorf("got %s, want years 1999 to 2008", body)
+	}
+	if got.TopAuthor == nil || got.TopAuthor.Author != "Martin Fowler" || got.TopAuthor.Count != 2 {
+		t.Errorf("got %s, want Martin Fowler with 2 books on top", body)
+	}
+}
+
+func TestBulkUpdateBooks(t *testing.T) {
+	app, s := newTestApp(t)
+	books := create(t, s,
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999},
+		models.Book{Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+	)
+	missing := uuid.NewString()
+
+	body := fmt.Sprintf(`{"ids":[%q,%q,%q],"changes":{"language":"en"}}`, books[0].ID, missing, books[1].ID)
+	status, resp := do(t, app, http.MethodPatch, "/api/books/bulk", body)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, resp)
+	}
+	var got struct {
+		Results []BulkPatchResult `json:"results"`
+	}
+	decode(t, resp, &got)
+	want := []BulkPatchResult{
+		{ID: books[0].ID, Status: "updated"},
+		{ID: missing, Status: "not_found"},
+		{ID: books[1].ID, Status: "updated"},
+	}
+	if !reflect.DeepEqual(got.Results, want) {
+		t.Errorf("results = %+v, want %+v", got.Results, want)
+	}
+
+	// A latitude without a longitude is invalid once merged.
+	body = fmt.Sprintf(`{"ids":[%q],"changes":{"latitude":10}}`, books[0].ID)
+	status, resp = do(t, app, http.MethodPatch, "/api/books/bulk", body)
+	if status != http.StatusOK {
+		t.Fatalf("invalid: status = %d, want %d: %s", status, http.StatusOK, resp)
+	}
+	decode(t, resp, &got)
+	if len(got.Results) != 1 || got.Results[0].Status != "invalid" ||
+		len(got.Results[0].Errors) != 1 || got.Results[0].Errors[0].Field != "longitude" {
+		t.Errorf("invalid: results = %s, want invalid with a longitude error", resp)
+	}
+	for _, b := range books {
+		updated, _ := s.Get(b.ID)
+		// Fields left out of the changes keep their values.
+		if updated.Language != "en" || updated.Year != b.Year || updated.Title != b.Title {
+			t.Errorf("%s: language=%q year=%d title=%q, want en, %d, %q", b.ID, updated.Language, updated.Year, updated.Title, b.Year, b.Title)
+		}
+	}
+
+	ids := make([]string, maxBulkIDs+1)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+	}
+	tooMany, _ := json.Marshal(map[string]interface{}{"ids": ids, "changes": map[string]string{"language": "en"}})
+	if status, _ := do(t, app, http.MethodPatch, "/api/books/bulk", string(tooMany)); status != http.StatusBadRequest {
+		t.Errorf("%d ids: status = %d, want %d", len(ids), status, http.StatusBadRequest)
+	}
+}
+
+func TestUpdateBooksByFilter(t *testing.T) {
+	app, s := newTestApp(t)
+	for _, b := range []models.Book{
+		{Title: "Refactoring", Author: "Martin Fowlr", Year: 1999},
+		{Title: "UML Distilled", Author: "martin fowlr "},
+		{Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+	} {
+		if _, err := s.Create(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, body := do(t, app, http.MethodPatch, "/api/books/", `{"filter":{"author":"Martin Fowlr"},"update":{"author":"Martin Fowler"}}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var got FilterPatchResult
+	decode(t, body, &got)
+	if got.Matched != 2 || got.Modified != 2 {
+		t.Errorf("got %+v, want 2 matched and modified", got)
+	}
+	all, _ := s.List()
+	for _, b := range all {
+		if b.Author == "Martin Fowlr" || strings.TrimSpace(b.Author) == "martin fowlr" {
+			t.Errorf("book %q still has author %q", b.Title, b.Author)
+		}
+		if b.Title == "Clean Code" && b.Author != "Robert C. Martin" {
+			t.Errorf("unmatched book changed: %+v", b)
+		}
+	}
+
+	tests := []struct {
+		target, body string
+		want         int
+	}{
+		{"/api/books/", `{"filter":{},"update":{"copies":1}}`, http.StatusBadRequest},
+		{"/api/books/", `{"filter":{"author":"Martin Fowler"},"update":{}}`, http.StatusBadRequest},
+		{"/api/books/", `{"filter":{"author":"Martin Fowler"},"update":{"copies":-1}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if status, resp := do(t, app, http.MethodPatch, tt.target, tt.body); status != tt.want {
+			t.Errorf("PATCH %s: status = %d, want %d: %s", tt.body, status, tt.want, resp)
+		}
+	}
+
+	status, body = do(t, app, http.MethodPatch, "/api/books/", `{"filter":{"author":"Martin Fowler"},"update":{"latitude":10}}`)
+	var verr ValidationErrorResponse
+	decode(t, body, &verr)
+	if status != http.StatusUnprocessableEntity || !strings.HasPrefix(verr.Error, "book ") ||
+		len(verr.Errors) != 1 || verr.Errors[0].Field != "longitude" {
+		t.Errorf("invalid merged book: status = %d, body = %s, want 422 naming the book and listing longitude", status, body)
+	}
+
+	status, body = do(t, app, http.MethodPatch, "/api/books/?all=true", `{"filter":{},"update":{"copies":3}}`)
+	if status != http.StatusOK {
+		t.Fatalf("all=true: status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	decode(t, body, &got)
+	if got.Matched != 3 || got.Modified != 3 {
+		t.Errorf("all=true: got %+v, want 3 matched and modified", got)
+	}
+}
+
+// create stores each book and returns them with their IDs.
+func create(t *testing.T, s *store.Store, books ...models.Book) []models.Book {
+	t.Helper()
+	created := make([]models.Book, len(books))
+	for i, b := range books {
+		var err error
+		if created[i], err = s.Create(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return created
+}
+
+// titles returns the titles of the books in the data array of a response.
+func titles(t *testing.T, app *fiber.App, target string) []string {
+	t.Helper()
+	status, body := do(t, app, http.MethodGet, target, "")
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status = %d, want %d: %s", target, status, http.StatusOK, body)
+	}
+	var got struct {
+		Data []models.Book `json:"data"`
+	}
+	decode(t, body, &got)
+	out := make([]string, len(got.Data))
+	for i, b := range got.Data {
+		out[i] = b.Title
+	}
+	return out
+}
+
+func TestRelatedBooks(t *testing.T) {
+	app, s := newTestApp(t)
+	books := create(t, s,
+		models.Book{Title: "Origin", Author: "Ann", Tags: []string{"go", "web"}},
+		models.Book{Title: "Same author, both tags", Author: "ann", Tags: []string{"Go", "web"}},
+		models.Book{Title: "Both tags", Author: "Bob", Tags: []string{"web", "go"}},
+		models.Book{Title: "Same author", Author: "Ann"},
+		models.Book{Title: "One tag", Author: "Cid", Tags: []string{"go", "cooking"}},
+		models.Book{Title: "Unrelated", Author: "Dan", Tags: []string{"cooking"}},
+	)
+	origin := "/api/books/" + books[0].ID + "/related"
+
+	want := []string{"Same author, both tags", "Both tags", "One tag", "Same author"}
+	if got := titles(t, app, origin); !slices.Equal(got, want) {
+		t.Errorf("related = %q, want %q", got, want)
+	}
+	if got := titles(t, app, origin+"?limit=2"); !slices.Equal(got, want[:2]) {
+		t.Errorf("limit=2: related = %q, want %q", got, want[:2])
+	}
+	if status, _ := do(t, app, http.MethodGet, "/api/books/"+uuid.NewString()+"/related", ""); status != http.StatusNotFound {
+		t.Errorf("unknown book: status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestRelatedBooksDepth(t *testing.T) {
+	app, s := newTestApp(t)
+	books := create(t, s,
+		models.Book{Title: "Origin", Author: "Ann", Tags: []string{"go"}},
+		models.Book{Title: "Hop 1", Author: "Bob", Tags: []string{"go", "web"}},
+		models.Book{Title: "Hop 2", Author: "Cid", Tags: []string{"web"}},
+		models.Book{Title: "Unrelated", Author: "Dan", Tags: []string{"cooking"}},
+	)
+	origin := "/api/books/" + books[0].ID + "/related"
+
+	depth1 := titles(t, app, origin+"?depth=1")
+	if want := []string{"Hop 1"}; !slices.Equal(depth1, want) {
+		t.Errorf("depth 1 = %q, want %q", depth1, want)
+	}
+	depth2 := titles(t, app, origin+"?depth=2")
+	if want := []string{"Hop 1", "Hop 2"}; !slices.Equal(depth2, want) {
+		t.Errorf("depth 2 = %q, want %q", depth2, want)
+	}
+	if status, _ := do(t, app, http.MethodGet, origin+"?depth=3", ""); status != http.StatusBadRequest {
+		t.Errorf("depth above RELATED_MAX_DEPTH: status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestAdjustCopiesConcurrently(t *testing.T) {
+	app, s := newTestApp(t)
+	b := create(t, s, models.Book{Title: "Clean Code", Author: "Robert C. Martin", Copies: 10})[0]
+	target := "/api/books/" + b.ID + "/copies:adjust"
+
+	// adjust sends every delta at once and counts the outcomes.
+	adjust := func(deltas ...int) (ok, conflicts int) {
+		var wg sync.WaitGroup
+		var nOK, nConflict atomic.Int64
+		for _, delta := range deltas {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(fmt.Sprintf(`{"delta":%d}`, delta)))
+				req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+				switch resp.StatusCode {
+				case http.StatusOK:
+					nOK.Add(1)
+				case http.StatusConflict:
+					nConflict.Add(1)
+				default:
+					t.Errorf("delta %d: status = %d", delta, resp.StatusCode)
+				}
+			}()
+		}
+		wg.Wait()
+		return int(nOK.Load()), int(nConflict.Load())
+	}
+	copies := func() int {
+		got, _ := s.Get(b.ID)
+		return got.Copies
+	}
+
+	var deltas []int
+	for i := 0; i < 30; i++ {
+		deltas = append(deltas, 2, -1)
+	}
+	if ok, _ := adjust(deltas...); ok != len(deltas) {
+		t.Fatalf("%d of %d adjustments succeeded, want all", ok, len(deltas))
+	}
+	if got := copies(); got != 40 {
+		t.Fatalf("after +60 and -30: copies = %d, want 40 (lost updates)", got)
+	}
+
+	checkouts := make([]int, 100)
+	for i := range checkouts {
+		checkouts[i] = -1
+	}
+	ok, conflicts := adjust(checkouts...)
+	if ok != 40 || conflicts != 60 {
+		t.Errorf("100 checkouts of 40 copies: %d succeeded and %d conflicted, want 40 and 60", ok, conflicts)
+	}
+	if got := copies(); got != 0 {
+		t.Errorf("after checking out every copy: copies = %d, want 0", got)
+	}
+}
+
+func TestOptionsDescribesResources(t *testing.T) {
+	tests := []struct {
+		name, target, allow string
+		disabled            []string
+		queryParam          string
+		contentType         string
+	}{
+		{"collection", "/api/books/", "GET, HEAD, POST, PATCH, OPTIONS", nil, "limit", fiber.MIMEApplicationJSON},
+		{"item", "/api/books/" + uuid.NewString(), "GET, HEAD, PUT, PATCH, DELETE, OPTIONS", nil, "", mimeApplicationJSONPatch},
+		{"item with DELETE disabled", "/api/books/" + uuid.NewString(), "GET, HEAD, PUT, PATCH, OPTIONS", []string{http.MethodDelete}, "", mimeApplicationMsgpack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.DisabledMethods = tt.disabled
+			app, _ := newTestAppWithConfig(t, cfg)
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodOptions, tt.target, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if got := resp.Header.Get(fiber.HeaderAllow); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			var caps ResourceCapabilities
+			if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+				t.Fatal(err)
+			}
+			methods := make([]string, len(caps.Operations))
+			for i, op := range caps.Operations {
+				methods[i] = op.Method
+				if op.Description == "" {
+					t.Errorf("operation %s has no description", op.Method)
+				}
+			}
+			if got := strings.Join(methods, ", "); got != tt.allow {
+				t.Errorf("operations = %s, want those in Allow: %s", got, tt.allow)
+			}
+			if tt.queryParam != "" && !slices.Contains(caps.QueryParams, tt.queryParam) {
+				t.Errorf("query_params = %v, want %s listed", caps.QueryParams, tt.queryParam)
+			}
+			if !slices.Contains(caps.ContentTypes, tt.contentType) {
+				t.Errorf("content_types = %v, want %s listed", caps.ContentTypes, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestResponseMeta(t *testing.T) {
+	cfg := config.Default()
+	cfg.ResponseMeta = true
+	h := New(store.New("", 1), cfg)
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+	app.Use(requestid.New(requestid.Config{Generator: uuid.NewString, ContextKey: RequestIDKey}))
+	h.Register(app.Group("/api").Group("/books"))
+
+	for _, target := range []string{"/api/books/", "/api/books/" + uuid.NewString()} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Meta *ResponseMeta `json:"meta"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil || body.Meta == nil {
+			t.Fatalf("GET %s: no meta object: %v", target, err)
+		}
+		if id := resp.Header.Get(fiber.HeaderXRequestID); id == "" || body.Meta.RequestID != id {
+			t.Errorf("GET %s: meta.requestId = %q, want the X-Request-ID %q", target, body.Meta.RequestID, id)
+		}
+		if since := time.Since(body.Meta.Timestamp); since < 0 || since > time.Minute {
+			t.Errorf("GET %s: meta.timestamp = %s, want the current time", target, body.Meta.Timestamp)
+		}
+	}
+
+	app, _ = newTestApp(t)
+	_, raw := do(t, app, http.MethodGet, "/api/books/", "")
+	if bytes.Contains(raw, []byte(`"meta"`)) {
+		t.Errorf("meta is sent while disabled: %s", raw)
+	}
+}
+
+func TestDefaultLimitHeader(t *testing.T) {
+	cfg := config.Default()
+	cfg.DefaultLimit, cfg.MaxLimit = 2, 5
+	app, s := newTestAppWithConfig(t, cfg)
+	seed(t, s, 10)
+
+	tests := []struct {
+		header, target string
+		want           int
+	}{
+		{"", "/api/books/", 2},
+		{"3", "/api/books/", 3},
+		{"3", "/api/books/?limit=4", 4},
+		{"8", "/api/books/", 5},
+		{"lots", "/api/books/", 2},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header != "" {
+			req.Header.Set(headerDefaultLimit, tt.header)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page struct {
+			Data  []models.Book `json:"data"`
+			Limit int           `json:"limit"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Data) != tt.want || page.Limit != tt.want {
+			t.Errorf("X-Default-Limit %q, GET %s: %d books, limit %d; want %d", tt.header, tt.target, len(page.Data), page.Limit, tt.want)
+		}
+	}
+}
+
+func TestListIDsOnly(t *testing.T) {
+	app, s := newTestApp(t)
+	books := create(t, s,
+		models.Book{Title: "A", Author: "Ann", Tags: []string{"go"}},
+		models.Book{Title: "B", Author: "Ann"},
+		models.Book{Title: "C", Author: "Ann", Tags: []string{"go"}},
+		models.Book{Title: "D", Author: "Ann", Tags: []string{"go"}},
+	)
+
+	status, body := do(t, app, http.MethodGet, "/api/books/?idsOnly=true&tag=go&sort=title&limit=2&page=2", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var page struct {
+		Data  []string `json:"data"`
+		Total int      `json:"total"`
+	}
+	decode(t, body, &page)
+	if want := []string{books[3].ID}; !slices.Equal(page.Data, want) || page.Total != 3 {
+		t.Errorf("data = %v, total = %d; want %v, 3", page.Data, page.Total, want)
+	}
+}
+
+func TestBooksExist(t *testing.T) {
+	app, s := newTestApp(t)
+	b := create(t, s, models.Book{Title: "Clean Code", Author: "Robert C. Martin"})[0]
+
+	body := `{"keys":[{"title":" clean code","author":"ROBERT C. MARTIN "},{"title":"Clean Code","author":"Someone Else"},{"title":"Refactoring","author":"Martin Fowler"}]}`
+	status, resp := do(t, app, http.MethodPost, "/api/books/exists", body)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, resp)
+	}
+	var got struct {
+		Results []BookExistsResult `json:"results"`
+	}
+	decode(t, resp, &got)
+	want := []BookExistsResult{
+		{Title: " clean code", Author: "ROBERT C. MARTIN ", Exists: true, ID: b.ID},
+		{Title: "Clean Code", Author: "Someone Else"},
+		{Title: "Refactoring", Author: "Martin Fowler"},
+	}
+	if !slices.Equal(got.Results, want) {
+		t.Errorf("results = %+v, want %+v", got.Results, want)
+	}
+
+	if status, _ := do(t, app, http.MethodPost, "/api/books/exists", `{"keys":[]}`); status != http.StatusBadRequest {
+		t.Errorf("no keys: status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("store holds %d books after exists checks, want 1", n)
+	}
+}
+
+func TestCreateNeverOverwrites(t *testing.T) {
+	app, s := newTestApp(t)
+	books := create(t, s,
+		models.Book{Title: "Refactoring", Author: "Martin Fowler"},
+		models.Book{Title: "Clean Code", Author: "Robert C. Martin"},
+	)
+	live, trashed := books[0], books[1]
+	if err := s.Delete(trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, taken := range []string{live.ID, trashed.ID} {
+		status, body := do(t, app, http.MethodPost, "/api/books/", fmt.Sprintf(`{"id":%q,"title":"Other %s","author":"Someone","year":2020}`, taken, taken))
+		if status != http.StatusCreated {
+			t.Fatalf("create with taken id: status = %d: %s", status, body)
+		}
+		var created models.Book
+		decode(t, body, &created)
+		if created.ID == taken {
+			t.Errorf("create reused the taken id %s", taken)
+		}
+	}
+	if got, _ := s.Get(live.ID); got.Title != live.Title || got.Version != live.Version {
+		t.Errorf("live book = %q version %d, want it unchanged", got.Title, got.Version)
+	}
+	if status, _ := do(t, app, http.MethodPost, "/api/books/"+trashed.ID+"/restore", ""); status != http.StatusOK {
+		t.Errorf("restoring the trashed book: status = %d, want %d", status, http.StatusOK)
+	}
+	if got, _ := s.Get(trashed.ID); got.Title != trashed.Title {
+		t.Errorf("restored book has title %q, want %q", got.Title, trashed.Title)
+	}
+}
+
+func TestBooksGeoJSON(t *testing.T) {
+	app, s := newTestApp(t)
+
+	for _, coords := range []string{
+		`"latitude":91,"longitude":0`,
+		`"latitude":0,"longitude":-180.5`,
+		`"latitude":10`,
+		`"longitude":10`,
+	} {
+		body := `{"title":"T","author":"A",` + coords + `}`
+		if status, resp := do(t, app, http.MethodPost, "/api/books/", body); status != http.StatusUnprocessableEntity {
+			t.Errorf("POST %s: status = %d, want %d: %s", body, status, http.StatusUnprocessableEntity, resp)
+		}
+	}
+
+	lat, lng := -6.2, 106.8
+	placed := create(t, s,
+		models.Book{Title: "Bumi Manusia", Author: "Pramoedya Ananta Toer", Year: 1980, PublishedCity: "Jakarta", Latitude: &lat, Longitude: &lng},
+		models.Book{Title: "Nowhere", Author: "Anonymous"},
+	)[0]
+
+	req := httptest.NewRequest(http.MethodGet, "/api/books/geojson", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get(fiber.HeaderContentType); got != "application/geo+json" {
+		t.Errorf("Content-Type = %q, want application/geo+json", got)
+	}
+	var got GeoJSONFeatureCollection
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "FeatureCollection" || len(got.Features) != 1 {
+		t.Fatalf("collection = %+v, want a FeatureCollection of the one placed book", got)
+	}
+	f := got.Features[0]
+	// GeoJSON puts longitude first.
+	if f.Type != "Feature" || f.ID != placed.ID || f.Geometry.Type != "Point" || f.Geometry.Coordinates != [2]float64{lng, lat} {
+		t.Errorf("feature = %+v, want point [%v %v] for %s", f, lng, lat, placed.ID)
+	}
+	if f.Properties["title"] != "Bumi Manusia" || f.Properties["published_city"] != "Jakarta" {
+		t.Errorf("properties = %v", f.Properties)
+	}
+}
+
+func TestTopAuthors(t *testing.T) {
+	app, s := newTestApp(t)
+	for author, n := range map[string]int{"Carol": 3, "alice": 2, "Bob": 2, "Dave": 1} {
+		for i := 0; i < n; i++ {
+			create(t, s, models.Book{Title: fmt.Sprintf("%s %d", author, i), Author: author})
+		}
+	}
+	create(t, s, models.Book{Title: "Another", Author: "ALICE "})
+
+	get := func(target string) []AuthorCount {
+		t.Helper()
+		status, body := do(t, app, http.MethodGet, target, "")
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status = %d: %s", target, status, body)
+		}
+		var got struct {
+			Data []AuthorCount `json:"data"`
+		}
+		decode(t, body, &got)
+		return got.Data
+	}
+
+	// Ties are broken alphabetically, ignoring case.
+	want := []AuthorCount{{"ALICE", 3}, {"Carol", 3}, {"Bob", 2}, {"Dave", 1}}
+	if got := get("/api/books/top-authors"); !slices.Equal(got, want) {
+		t.Errorf("top authors = %v, want %v", got, want)
+	}
+	if got := get("/api/books/top-authors?limit=2"); !slices.Equal(got, want[:2]) {
+		t.Errorf("limit=2: top authors = %v, want %v", got, want[:2])
+	}
+	for _, limit := range []string{"0", "abc", strconv.Itoa(maxTopAuthors + 1)} {
+		if status, _ := do(t, app, http.MethodGet, "/api/books/top-authors?limit="+limit, ""); status != http.StatusBadRequest {
+			t.Errorf("limit=%s: status = %d, want %d", limit, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestWriteWarnings(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	warnings := func(status int, body []byte, wantStatus int) []string {
+		t.Helper()
+		if status != wantStatus {
+			t.Fatalf("status = %d, want %d: %s", status, wantStatus, body)
+		}
+		var got BookWriteResponse
+		decode(t, body, &got)
+		if got.ID == "" {
+			t.Errorf("response has no book: %s", body)
+		}
+		return got.Warnings
+	}
+
+	status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin"}`)
+	if got := warnings(status, body, http.StatusCreated); !slices.Equal(got, []string{"year is missing"}) {
+		t.Errorf("create without year: warnings = %q, want year is missing", got)
+	}
+	var created models.Book
+	decode(t, body, &created)
+
+	status, body = do(t, app, http.MethodPatch, "/api/books/"+created.ID, `{"year":2008}`)
+	if got := warnings(status, body, http.StatusOK); got != nil {
+		t.Errorf("update with a year: warnings = %q, want none", got)
+	}
+	status, body = do(t, app, http.MethodPatch, "/api/books/"+created.ID, `{"title":"C"}`)
+	if got := warnings(status, body, http.StatusOK); !slices.Equal(got, []string{"title is suspiciously short"}) {
+		t.Errorf("update with a short title: warnings = %q, want title is suspiciously short", got)
+	}
+}
+
+func TestGenerateMissingTitles(t *testing.T) {
+	const untitled = `{"author":"Anonymous","year":1920}`
+
+	app, _ := newTestApp(t)
+	if status, body := do(t, app, http.MethodPost, "/api/books/", untitled); status != http.StatusUnprocessableEntity {
+		t.Errorf("default: status = %d, want %d: %s", status, http.StatusUnprocessableEntity, body)
+	}
+
+	cfg := config.Default()
+	cfg.GenerateMissingTitles = true
+	app, _ = newTestAppWithConfig(t, cfg)
+	status, body := do(t, app, http.MethodPost, "/api/books/", untitled)
+	if status != http.StatusCreated {
+		t.Fatalf("enabled: status = %d, want %d: %s", status, http.StatusCreated, body)
+	}
+	var created models.Book
+	decode(t, body, &created)
+	if created.Title != "Untitled by Anonymous (1920)" || !created.GeneratedTitle {
+		t.Errorf("title = %q, generatedTitle = %v; want Untitled by Anonymous (1920), true", created.Title, created.GeneratedTitle)
+	}
+
+	// Without a year there is not enough to build a title from.
+	if status, body := do(t, app, http.MethodPost, "/api/books/", `{"author":"Anonymous"}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("enabled, no year: status = %d, want %d: %s", status, http.StatusUnprocessableEntity, body)
+	}
+	status, body = do(t, app, http.MethodPost, "/api/books/", `{"title":"Real","author":"Anonymous","year":1920,"generatedTitle":true}`)
+	var titled models.Book
+	decode(t, body, &titled)
+	if status != http.StatusCreated || titled.GeneratedTitle {
+		t.Errorf("given title: status = %d, generatedTitle = %v; want 201, false", status, titled.GeneratedTitle)
+	}
+}
+
+func TestCitation(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin","year":2008,"citation":"forged"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", status, body)
+	}
+	var created models.BookView
+	decode(t, body, &created)
+	if want := "Robert C. Martin, Clean Code (2008)"; created.Citation != want {
+		t.Errorf("create: citation = %q, want %q", created.Citation, want)
+	}
+
+	status, body = do(t, app, http.MethodPatch, "/api/books/"+created.ID, `{"year":null,"citation":"forged"}`)
+	if status != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", status, body)
+	}
+	_, body = do(t, app, http.MethodGet, "/api/books/"+created.ID, "")
+	var got models.BookView
+	decode(t, body, &got)
+	if want := "Robert C. Martin, Clean Code"; got.Citation != want {
+		t.Errorf("without a year: citation = %q, want %q", got.Citation, want)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	app, s := newTestApp(t)
+
+	status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Laskar Pelangi","author":"Andrea Hirata","language":" ID "}`)
+	if status != http.StatusCreated {
+		t.Fatalf("valid code: status = %d: %s", status, body)
+	}
+	var created models.Book
+	decode(t, body, &created)
+	if created.Language != "id" {
+		t.Errorf("language = %q, want it normalized to id", created.Language)
+	}
+	for _, lang := range []string{"xx", "eng", "english"} {
+		body := fmt.Sprintf(`{"title":"T","author":"A","language":%q}`, lang)
+		if status, resp := do(t, app, http.MethodPost, "/api/books/", body); status != http.StatusUnprocessableEntity {
+			t.Errorf("language %q: status = %d, want %d: %s", lang, status, http.StatusUnprocessableEntity, resp)
+		}
+	}
+
+	create(t, s,
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", Language: "en"},
+		models.Book{Title: "Untagged", Author: "Anonymous"},
+	)
+	if got := titles(t, app, "/api/books/?language=ID"); !slices.Equal(got, []string{"Laskar Pelangi"}) {
+		t.Errorf("language=ID: titles = %q, want [Laskar Pelangi]", got)
+	}
+	if got := titles(t, app, "/api/books/?language=fr"); len(got) != 0 {
+		t.Errorf("language=fr: titles = %q, want none", got)
+	}
+}
+
+func TestStrictQuery(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 1)
+	if status, body := do(t, app, http.MethodGet, "/api/books/?colour=red", ""); status != http.StatusOK {
+		t.Errorf("lenient: status = %d, want unknown parameters ignored: %s", status, body)
+	}
+
+	cfg := config.Default()
+	cfg.StrictQuery = true
+	app, s = newTestAppWithConfig(t, cfg)
+	seed(t, s, 1)
+	status, body := do(t, app, http.MethodGet, "/api/books/?limit=1&colour=red", "")
+	if status != http.StatusBadRequest || !strings.Contains(string(body), "colour") {
+		t.Errorf("strict: status = %d, body = %s; want %d naming the parameter", status, body, http.StatusBadRequest)
+	}
+	if status, body := do(t, app, http.MethodGet, "/api/books/?limit=1&page=1", ""); status != http.StatusOK {
+		t.Errorf("strict with known parameters: status = %d: %s", status, body)
+	}
+}
+
+func TestEmptyListNoContent(t *testing.T) {
+	app, _ := newTestApp(t)
+	status, body := do(t, app, http.MethodGet, "/api/books/", "")
+	if status != http.StatusOK || !strings.Contains(string(body), `"data":[]`) {
+		t.Errorf("default: status = %d, body = %s; want 200 with an empty array", status, body)
+	}
+
+	cfg := config.Default()
+	cfg.EmptyListNoContent = true
+	app, s := newTestAppWithConfig(t, cfg)
+	status, body = do(t, app, http.MethodGet, "/api/books/", "")
+	if status != http.StatusNoContent || len(body) != 0 {
+		t.Errorf("enabled: status = %d, body = %q; want 204 without a body", status, body)
+	}
+	seed(t, s, 1)
+	if status, _ := do(t, app, http.MethodGet, "/api/books/?page=5", ""); status != http.StatusNoContent {
+		t.Errorf("enabled, page beyond the end: status = %d, want %d", status, http.StatusNoContent)
+	}
+	if status, _ := do(t, app, http.MethodGet, "/api/books/", ""); status != http.StatusOK {
+		t.Errorf("enabled, non-empty page: status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestUpdateBookRejectsWhitespaceOnlyTitle(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{`{"title":"   "}`, `{"title":"\t\n"}`, `{"title":" ","year":2018}`} {
+		status, resp := do(t, app, http.MethodPatch, "/api/books/"+b.ID, body)
+		if status != http.StatusUnprocessableEntity || !strings.Contains(string(resp), "title must not be blank") {
+			t.Errorf("PATCH %s: status = %d, body = %s; want %d naming title", body, status, resp, http.StatusUnprocessableEntity)
+		}
+	}
+	if got, _ := s.Get(b.ID); got.Title != b.Title || got.Year != b.Year || got.Version != b.Version {
+		t.Errorf("book changed: got %+v, had %+v", got, b)
+	}
+
+	// A title with surrounding whitespace is trimmed, not rejected.
+	if status, resp := do(t, app, http.MethodPatch, "/api/books/"+b.ID, `{"title":"  Refactoring, 2nd ed.  "}`); status != http.StatusOK {
+		t.Fatalf("PATCH padded title: status = %d: %s", status, resp)
+	}
+	if got, _ := s.Get(b.ID); got.Title != "Refactoring, 2nd ed." {
+		t.Errorf("title = %q, want it trimmed", got.Title)
+	}
+}
+
+func TestSample(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 20)
+
+	sample := func(target string) ([]string, int64) {
+		t.Helper()
+		status, body := do(t, app, http.MethodGet, target, "")
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status = %d: %s", target, status, body)
+		}
+		var got struct {
+			Data []models.Book `json:"data"`
+			Seed int64         `json:"seed"`
+		}
+		decode(t, body, &got)
+		ids := make([]string, len(got.Data))
+		for i, b := range got.Data {
+			ids[i] = b.ID
+		}
+		return ids, got.Seed
+	}
+
+	first, _ := sample("/api/books/sample?size=5&seed=42")
+	if len(first) != 5 {
+		t.Fatalf("sample has %d books, want 5", len(first))
+	}
+	if again, _ := sample("/api/books/sample?size=5&seed=42"); !slices.Equal(again, first) {
+		t.Errorf("same seed gave %v, then %v", first, again)
+	}
+	if other, _ := sample("/api/books/sample?size=5&seed=43"); slices.Equal(other, first) {
+		t.Errorf("seeds 42 and 43 gave the same sample %v", first)
+	}
+
+	// Without a seed the one used is returned and reproduces the sample.
+	random, used := sample("/api/books/sample?size=5")
+	if again, _ := sample("/api/books/sample?size=5&seed=" + strconv.FormatInt(used, 10)); !slices.Equal(again, random) {
+		t.Errorf("returned seed %d gave %v, want %v", used, again, random)
+	}
+
+	for _, target := range []string{"/api/books/sample?size=0", "/api/books/sample?size=101", "/api/books/sample?seed=x"} {
+		if status, _ := do(t, app, http.MethodGet, target, ""); status != http.StatusBadRequest {
+			t.Errorf("GET %s: status = %d, want %d", target, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCreateDuplicateBook(t *testing.T) {
+	app, s := newTestApp(t)
+	existing := create(t, s, models.Book{Title: "Refactoring", Author: "Martin Fowler"})[0]
+
+	// Titles and authors are compared ignoring case and surrounding spaces.
+	dup := `{"title":"  REFACTORING ","author":"martin fowler"}`
+	status, body := do(t, app, http.MethodPost, "/api/books/", dup)
+	var got ErrorResponse
+	decode(t, body, &got)
+	if status != http.StatusConflict || got.Code != "conflict" || !strings.Contains(got.Error, existing.ID) {
+		t.Errorf("duplicate: status = %d, body = %s; want %d naming %s", status, body, http.StatusConflict, existing.ID)
+	}
+	if all, _ := s.List(); len(all) != 1 {
+		t.Fatalf("%d books after a rejected duplicate, want 1", len(all))
+	}
+
+	status, body = do(t, app, http.MethodPost, "/api/books/?force=true", dup)
+	if status != http.StatusCreated {
+		t.Fatalf("forced duplicate: status = %d, want %d: %s", status, http.StatusCreated, body)
+	}
+	var created models.Book
+	decode(t, body, &created)
+	if created.ID == existing.ID {
+		t.Errorf("forced duplicate reused id %s", existing.ID)
+	}
+	if all, _ := s.List(); len(all) != 2 {
+		t.Errorf("%d books after a forced duplicate, want 2", len(all))
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	cfg := config.Default()
+	cfg.BodyLimit = 1024
+	app, s := newTestAppWithConfig(t, cfg)
+	// app.Test fails outright on an oversized body instead of answering,
+	// so go through a real connection.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	big := fmt.Sprintf(`{"title":%q,"author":"Author"}`, strings.Repeat("x", cfg.BodyLimit))
+	resp, err := http.Post("http://"+ln.Addr().String()+"/api/books/", fiber.MIMEApplicationJSON, strings.NewReader(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := resp.StatusCode
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ErrorResponse
+	decode(t, body, &got)
+	if want := (ErrorResponse{Error: "request body too large (max 1024 bytes)", Code: "request_entity_too_large"}); status != http.StatusRequestEntityTooLarge || got != want {
+		t.Errorf("oversized body: status = %d, body = %s; want %d %+v", status, body, http.StatusRequestEntityTooLarge, want)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("oversized body: %d books created, want none", n)
+	}
+
+	app, s = newTestApp(t)
+	for _, n := range []int{maxBulkCreate + 1, maxBulkCreate} {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf(`{"title":"Book %d","author":"Author"}`, i)
+		}
+		status, body := do(t, app, http.MethodPost, "/api/books/bulk", "["+strings.Join(items, ",")+"]")
+		if n > maxBulkCreate {
+			decode(t, body, &got)
+			if status != http.StatusRequestEntityTooLarge || got.Code != "request_entity_too_large" {
+				t.Errorf("%d books: status = %d, body = %s; want %d", n, status, body, http.StatusRequestEntityTooLarge)
+			}
+			if all, _ := s.List(); len(all) != 0 {
+				t.Errorf("%d books: %d created, want none", n, len(all))
+			}
+		} else if status != http.StatusCreated {
+			t.Errorf("%d books: status = %d, want %d: %s", n, status, http.StatusCreated, body)
+		}
+	}
+}
+
+func TestUniqueTitlePerAuthor(t *testing.T) {
+	app, _ := newTestApp(t)
+	post := `{"title":"Refactoring","author":"Martin Fowler"}`
+	do(t, app, http.MethodPost, "/api/books/", post)
+	if status, body := do(t, app, http.MethodPost, "/api/books/?force=true", post); status != http.StatusCreated {
+		t.Errorf("disabled: forced duplicate: status = %d, want %d: %s", status, http.StatusCreated, body)
+	}
+
+	cfg := config.Default()
+	cfg.UniqueTitlePerAuthor = true
+	app, s := newTestAppWithConfig(t, cfg)
+	books := create(t, s,
+		models.Book{Title: "Refactoring", Author: "Martin Fowler"},
+		models.Book{Title: "Analysis Patterns", Author: "Martin Fowler"},
+	)
+
+	// Forcing skips the dedupe check but not this rule.
+	status, body := do(t, app, http.MethodPost, "/api/books/?force=true", `{"title":" refactoring ","author":"MARTIN FOWLER"}`)
+	if status != http.StatusConflict || !strings.Contains(string(body), books[0].ID) {
+		t.Errorf("create: status = %d, body = %s; want %d naming %s", status, body, http.StatusConflict, books[0].ID)
+	}
+	if status, body := do(t, app, http.MethodPatch, "/api/books/"+books[1].ID, `{"title":"Refactoring"}`); status != http.StatusConflict {
+		t.Errorf("rename: status = %d, want %d: %s", status, http.StatusConflict, body)
+	}
+	if got, _ := s.Get(books[1].ID); got.Title != "Analysis Patterns" {
+		t.Errorf("title = %q after a rejected rename", got.Title)
+	}
+
+	if status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Refactoring","author":"Kent Beck"}`); status != http.StatusCreated {
+		t.Errorf("same title, other author: status = %d, want %d: %s", status, http.StatusCreated, body)
+	}
+	// Updating a book without renaming it does not conflict with itself.
+	if status, body := do(t, app, http.MethodPatch, "/api/books/"+books[0].ID, `{"year":1999}`); status != http.StatusOK {
+		t.Errorf("update in place: status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+}
+
+func TestMsgpackRoundTripMatchesJSON(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	send := func(method, target, accept string, body []byte) []byte {
+		t.Helper()
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set(fiber.HeaderAccept, accept)
+		if body != nil {
+			req.Header.Set(fiber.HeaderContentType, mimeApplicationMsgpack)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode >= 300 {
+			t.Fatalf("%s %s: status = %d: %s", method, target, resp.StatusCode, raw)
+		}
+		if got := resp.Header.Get(fiber.HeaderContentType); !strings.HasPrefix(got, accept) {
+			t.Fatalf("%s %s: Content-Type = %q, want %s", method, target, got, accept)
+		}
+		return raw
+	}
+	// asJSON brings a MessagePack body into its JSON form for comparison.
+	asJSON := func(raw []byte) map[string]interface{} {
+		t.Helper()
+		var v map[string]interface{}
+		if err := msgpack.Unmarshal(raw, &v); err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		decode(t, b, &out)
+		return out
+	}
+
+	in := map[string]interface{}{"title": "Refactoring", "author": "Martin Fowler", "year": 1999, "tags": []string{"design"}}
+	body, err := msgpack.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := asJSON(send(http.MethodPost, "/api/books/", mimeApplicationMsgpack, body))
+	id, _ := created["id"].(string)
+	if id == "" || created["title"] != "Refactoring" || created["year"] != float64(1999) {
+		t.Fatalf("created = %v, want the book sent", created)
+	}
+
+	viaMsgpack := asJSON(send(http.MethodGet, "/api/books/"+id, mimeApplicationMsgpack, nil))
+	var viaJSON map[string]interface{}
+	decode(t, send(http.MethodGet, "/api/books/"+id, fiber.MIMEApplicationJSON, nil), &viaJSON)
+	// Every read counts as a view, so the counts differ by design.
+	delete(viaMsgpack, "views")
+	delete(viaJSON, "views")
+	if fmt.Sprint(viaMsgpack) != fmt.Sprint(viaJSON) {
+		t.Errorf("MessagePack book %v differs from JSON book %v", viaMsgpack, viaJSON)
+	}
+
+	list := asJSON(send(http.MethodGet, "/api/books/", mimeApplicationMsgpack, nil))
+	data, _ := list["data"].([]interface{})
+	if len(data) == 1 {
+		delete(data[0].(map[string]interface{}), "views")
+	}
+	if len(data) != 1 || fmt.Sprint(data[0]) != fmt.Sprint(viaJSON) {
+		t.Errorf("MessagePack list data = %v, want [%v]", list["data"], viaJSON)
+	}
+}
+
+func TestListSinceVersion(t *testing.T) {
+	app, s := newTestApp(t)
+	books := create(t, s,
+		models.Book{Title: "Refactoring", Author: "Martin Fowler"},
+		models.Book{Title: "Clean Code", Author: "Robert C. Martin"},
+		models.Book{Title: "Domain-Driven Design", Author: "Eric Evans"},
+	)
+
+	type changes struct {
+		Data    []models.Book `json:"data"`
+		Deleted []string      `json:"deleted"`
+		Reset   bool          `json:"reset"`
+		Version int64         `json:"version"`
+	}
+	poll := func(since int64) (int, changes) {
+		t.Helper()
+		status, body := do(t, app, http.MethodGet, "/api/books/?sinceVersion="+strconv.FormatInt(since, 10), "")
+		var got changes
+		if status == http.StatusOK {
+			decode(t, body, &got)
+		}
+		return status, got
+	}
+
+	_, first := poll(0)
+	if len(first.Data) != 3 || len(first.Deleted) != 0 || first.Reset {
+		t.Fatalf("since 0: got %+v, want every book", first)
+	}
+	if status, _ := poll(first.Version); status != http.StatusNotModified {
+		t.Errorf("no changes: status = %d, want %d", status, http.StatusNotModified)
+	}
+	if status, body := do(t, app, http.MethodGet, "/api/books/?sinceVersion="+strconv.FormatInt(first.Version+1, 10), ""); status != http.StatusBadRequest {
+		t.Errorf("future version: status = %d, want %d: %s", status, http.StatusBadRequest, body)
+	}
+
+	do(t, app, http.MethodPatch, "/api/books/"+books[0].ID, `{"year":1999}`)
+	do(t, app, http.MethodDelete, "/api/books/"+books[1].ID, "")
+	status, got := poll(first.Version)
+	if status != http.StatusOK || len(got.Data) != 1 || got.Data[0].ID != books[0].ID {
+		t.Fatalf("changed: status = %d, data = %+v, want only the updated book", status, got.Data)
+	}
+	if !slices.Equal(got.Deleted, []string{books[1].ID}) || got.Reset {
+		t.Errorf("changed: deleted = %v, reset = %v, want [%s] and false", got.Deleted, got.Reset, books[1].ID)
+	}
+
+	// A purge leaves nothing to report, so pollers from before it start over.
+	do(t, app, http.MethodDelete, "/api/books/"+books[2].ID+"?hard=true", "")
+	if _, got := poll(first.Version); !got.Reset || len(got.Data) != 1 || len(got.Deleted) != 0 {
+		t.Errorf("after purge: got %+v, want a reset to the one remaining book", got)
+	}
+}
+
+func TestRandomBookMatchesFilters(t *testing.T) {
+	app, s := newTestApp(t)
+	create(t, s,
+		models.Book{Title: "Dune", Author: "Frank Herbert", Year: 1965, Tags: []string{"fiction"}},
+		models.Book{Title: "Children of Dune", Author: "Frank Herbert", Year: 1976, Tags: []string{"fiction"}},
+		models.Book{Title: "Neuromancer", Author: "William Gibson", Year: 1984, Tags: []string{"fiction"}},
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999, Tags: []string{"programming"}},
+	)
+
+	for target, want := range map[string][]string{
+		"/api/books/random?tag=FICTION":                             {"Dune", "Children of Dune", "Neuromancer"},
+		"/api/books/random?author=frank%20herbert":                  {"Dune", "Children of Dune"},
+		"/api/books/random?tag=fiction&year_min=1970&year_max=1990": {"Children of Dune", "Neuromancer"},
+	} {
+		for i := 0; i < 20; i++ {
+			status, body := do(t, app, http.MethodGet, target, "")
+			if status != http.StatusOK {
+				t.Fatalf("GET %s: status = %d: %s", target, status, body)
+			}
+			var b models.Book
+			decode(t, body, &b)
+			if !slices.Contains(want, b.Title) {
+				t.Fatalf("GET %s returned %q, want one of %q", target, b.Title, want)
+			}
+		}
+	}
+
+	status, body := do(t, app, http.MethodGet, "/api/books/random?author=Frank%20Herbert&count=5", "")
+	var got struct {
+		Data []models.Book `json:"data"`
+	}
+	decode(t, body, &got)
+	if status != http.StatusOK || len(got.Data) != 2 {
+		t.Errorf("count=5 by author: status = %d, %d books; want both by the author", status, len(got.Data))
+	}
+	if status, body := do(t, app, http.MethodGet, "/api/books/random?tag=poetry", ""); status != http.StatusNotFound {
+		t.Errorf("no match: status = %d, want %d: %s", status, http.StatusNotFound, body)
+	}
+}
+
+func TestListWithoutTag(t *testing.T) {
+	app, s := newTestApp(t)
+	create(t, s,
+		models.Book{Title: "Dune", Author: "Frank Herbert", Tags: []string{"fiction", "classic"}},
+		models.Book{Title: "Neuromancer", Author: "William Gibson", Tags: []string{"fiction"}},
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", Tags: []string{"programming"}},
+		models.Book{Title: "Untagged", Author: "Anonymous"},
+	)
+
+	for target, want := range map[string][]string{
+		"/api/books/?withoutTag=FICTION&sort=title":                    {"Refactoring", "Untagged"},
+		"/api/books/?withoutTag=fiction&withoutTag=programming":        {"Untagged"},
+		"/api/books/?tag=fiction&withoutTag=classic":                   {"Neuromancer"},
+		"/api/books/?untagged=true":                                    {"Untagged"},
+		"/api/books/?untagged=true&author=Martin%20Fowler":             {},
+		"/api/books/?untagged=false&withoutTag=programming&sort=title": {"Dune", "Neuromancer", "Untagged"},
+	} {
+		if got := titles(t, app, target); !slices.Equal(got, want) {
+			t.Errorf("GET %s: titles = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestListEmbedStats(t *testing.T) {
+	app, s := newTestApp(t)
+	create(t, s,
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999},
+		models.Book{Title: "Analysis Patterns", Author: "Martin Fowler", Year: 1996},
+		models.Book{Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+	)
+
+	status, body := do(t, app, http.MethodGet, "/api/books/?author=Robert%20C.%20Martin&embed=stats", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, body)
+	}
+	var got struct {
+		Data  []models.Book   `json:"data"`
+		Stats json.RawMessage `json:"stats"`
+	}
+	decode(t, body, &got)
+	if len(got.Data) != 1 || got.Data[0].Title != "Clean Code" {
+		t.Errorf("data = %+v, want the filtered page", got.Data)
+	}
+	// The stats cover the whole catalog, like /books/stats.
+	_, want := do(t, app, http.MethodGet, "/api/books/stats", "")
+	if string(got.Stats) != strings.TrimSpace(string(want)) || !strings.Contains(string(want), `"total":3`) {
+		t.Errorf("stats = %s, want %s", got.Stats, want)
+	}
+
+	_, body = do(t, app, http.MethodGet, "/api/books/", "")
+	if strings.Contains(string(body), `"stats"`) {
+		t.Errorf("stats sent without embed: %s", body)
+	}
+	if status, _ := do(t, app, http.MethodGet, "/api/books/?embed=authors", ""); status != http.StatusBadRequest {
+		t.Errorf("embed=authors: status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestGetBookCacheControl(t *testing.T) {
+	for _, tc := range []struct {
+		maxAge time.Duration
+		want   string
+	}{
+		{0, "no-cache"},
+		{90 * time.Second, "max-age=90"},
+	} {
+		cfg := config.Default()
+		cfg.CacheMaxAge = tc.maxAge
+		app, s := newTestAppWithConfig(t, cfg)
+		b := create(t, s, models.Book{Title: "Refactoring", Author: "Martin Fowler"})[0]
+
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/books/"+b.ID, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		etag := resp.Header.Get(fiber.HeaderETag)
+		if got := resp.Header.Get(fiber.HeaderCacheControl); got != tc.want || etag == "" {
+			t.Errorf("max age %s: Cache-Control = %q, ETag = %q; want %q with an ETag", tc.maxAge, got, etag, tc.want)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/books/"+b.ID, nil)
+		req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+		resp, err = app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get(fiber.HeaderCacheControl); resp.StatusCode != http.StatusNotModified || got != tc.want || resp.Header.Get(fiber.HeaderETag) != etag {
+			t.Errorf("max age %s, revalidated: status = %d, Cache-Control = %q; want %d with %q and the same ETag",
+				tc.maxAge, resp.StatusCode, got, http.StatusNotModified, tc.want)
+		}
+	}
+}
+
+func TestListHugePageIsEmpty(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 5)
+	for _, target := range []string{
+		"/api/books/?page=4611686018427387904&limit=4",
+		"/api/books/?page=4611686018427387905&limit=4",
+		"/api/books/search?q=Book&page=4611686018427387905&limit=4",
+	} {
+		status, body := do(t, app, http.MethodGet, target, "")
+		if status != http.StatusOK || !strings.Contains(string(body), `"data":[]`) {
+			t.Errorf("GET %s: status = %d, body = %s; want 200 with an empty array", target, status, body)
+		}
+	}
+}
+
+func TestStaleIfMatchIsRejected(t *testing.T) {
+	app, s := newTestApp(t)
+	b := create(t, s, models.Book{Title: "Refactoring", Author: "Martin Fowler"})[0]
+
+	write := func(method, etag, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/books/"+b.ID, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderIfMatch, etag)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	stale := bookETag(b)
+	if status := write(http.MethodPatch, stale, `{"year":1999}`); status != http.StatusOK {
+		t.Fatalf("PATCH with the current ETag: status = %d, want %d", status, http.StatusOK)
+	}
+	updated, _ := s.Get(b.ID)
+
+	if status := write(http.MethodPatch, stale, `{"year":2018}`); status != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a stale ETag: status = %d, want %d", status, http.StatusPreconditionFailed)
+	}
+	if status := write(http.MethodPut, stale, `{"title":"Refactoring, 2nd Edition","author":"Martin Fowler"}`); status != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag: status = %d, want %d", status, http.StatusPreconditionFailed)
+	}
+	if got, _ := s.Get(b.ID); got.Version != updated.Version || got.Year != 1999 || got.Title != "Refactoring" {
+		t.Errorf("book changed by stale writes: got %+v, want %+v", got, updated)
+	}
+}
+
+func TestCountBooks(t *testing.T) {
+	app, s := newTestApp(t)
+	create(t, s,
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999},
+		models.Book{Title: "Clean Code", Author: "Robert C. Martin", Year: 2008},
+		models.Book{Title: "The Clean Coder", Author: "Robert C. Martin", Year: 2011},
+		models.Book{Title: "Domain-Driven Design", Author: "Eric Evans"},
+	)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 4},
+		{"q=clean", 2},
+		{"q=MARTIN", 3},
+		{"year_min=2000", 2},
+		{"year_max=2008", 2},
+		{"year_min=2000&year_max=2010", 1},
+		{"q=martin&year_min=2009", 1},
+		{"q=nothing", 0},
+	}
+	for _, tt := range tests {
+		status, body := do(t, app, http.MethodGet, "/api/books/count?"+tt.query, "")
+		if status != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d: %s", tt.query, status, http.StatusOK, body)
+			continue
+		}
+		var got struct {
+			Total int `json:"total"`
+		}
+		decode(t, body, &got)
+		if got.Total != tt.want {
+			t.Errorf("%s: total = %d, want %d", tt.query, got.Total, tt.want)
+		}
+	}
+
+	for _, query := range []string{"year_min=x", "year_min=0", "year_min=2010&year_max=2000"} {
+		if status, body := do(t, app, http.MethodGet, "/api/books/count?"+query, ""); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d: %s", query, status, http.StatusBadRequest, body)
+		}
+	}
+}
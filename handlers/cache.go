@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"container/list"
+	"sync"
+)
+
+// bookCache is an LRU cache of the JSON bodies of single books, so books
+// read over and over are not marshaled for every request. The store drops
+// a book's entry whenever it writes the book.
+type bookCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *cachedBook, most recently used first
+	entries map[string]*list.Element
+}
+
+type cachedBook struct {
+	id string
+	// version is the version of the book the body was marshaled from. A
+	// read that raced with a write may put a stale body; it is never served
+	// because the stored book's version no longer matches.
+	version int64
+	body    []byte
+}
+
+func newBookCache(size int) *bookCache {
+	return &bookCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the cached body of version of the book with the given ID.
+func (c *bookCache) get(id string, version int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok || e.Value.(*cachedBook).version != version {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedBook).body, true
+}
+
+// put caches body, evicting the least recently used book if the cache is
+// full. id must not alias a request buffer, as it is kept as a map key.
+func (c *bookCache) put(id string, version int64, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok {
+		e.Value = &cachedBook{id: id, version: version, body: body}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[id] = c.order.PushFront(&cachedBook{id: id, version: version, body: body})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedBook).id)
+	}
+}
+
+// remove drops the book with the given ID from the cache.
+func (c *bookCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok {
+		c.order.Remove(e)
+		delete(c.entries, id)
+	}
+}
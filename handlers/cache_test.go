@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"demo-golang/config"
+	"demo-golang/models"
+	"demo-golang/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBookCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newBookCache(2)
+	c.put("a", 1, []byte("a"))
+	c.put("b", 1, []byte("b"))
+	c.get("a", 1)
+	c.put("c", 1, []byte("c"))
+
+	if _, ok := c.get("b", 1); ok {
+		t.Error("b is still cached, want it evicted")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := c.get(id, 1); !ok {
+			t.Errorf("%s is not cached", id)
+		}
+	}
+	if _, ok := c.get("a", 2); ok {
+		t.Error("a is served for another version")
+	}
+}
+
+func TestGetBookUsesCache(t *testing.T) {
+	s := store.New("", 1)
+	cfg := config.Default()
+	cfg.BookCacheSize = 10
+	h := New(s, cfg)
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+	app.Get("/metrics", h.Metrics())
+	h.Register(app.Group("/api").Group("/books"))
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do(t, app, http.MethodGet, "/api/books/"+b.ID, "")
+	do(t, app, http.MethodGet, "/api/books/"+b.ID, "")
+	if status, body := do(t, app, http.MethodPatch, "/api/books/"+b.ID, `{"year":2018}`); status != http.StatusOK {
+		t.Fatalf("patch: status = %d: %s", status, body)
+	}
+	if _, ok := h.cache.get(b.ID, b.Version); ok {
+		t.Error("the book is still cached after an update")
+	}
+	status, body := do(t, app, http.MethodGet, "/api/books/"+b.ID, "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var got models.Book
+	decode(t, body, &got)
+	if got.Year != 2018 {
+		t.Errorf("year = %d after the update, want 2018", got.Year)
+	}
+
+	_, body = do(t, app, http.MethodGet, "/metrics", "")
+	for _, want := range []string{"book_cache_hits_total 1", "book_cache_misses_total 2"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
+
+func TestCachedBookHasViews(t *testing.T) {
+	s := store.New("", 1)
+	cfg := config.Default()
+	cfg.BookCacheSize = 10
+	h := New(s, cfg)
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+	h.Register(app.Group("/api").Group("/books"))
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		_, body := do(t, app, http.MethodGet, "/api/books/"+b.ID, "")
+		var got models.Book
+		decode(t, body, &got)
+		if got.Views != want || got.Title != b.Title {
+			t.Errorf("read %d: got %s, want %d views", want, body, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"demo-golang/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cursorParams are the query parameters a cursor carries, so that later
+// pages keep the sort and filters of the first one without the client
+// sending them again.
+var cursorParams = []string{"sort", "author", "language", "tag", "withoutTag", "untagged", "year_min", "year_max"}
+
+// pageToken is what a cursor encodes: the cursorParams of the listing and
+// the sort key of the last book before the page.
+type pageToken struct {
+	Params string       `json:"p,omitempty"`
+	After  *models.Book `json:"a"`
+}
+
+// encodeCursor returns the cursor of the page after last, for the sort and
+// filters of c. It is the token as base64 JSON, a dot and its HMAC, so
+// clients cannot forge or alter it.
+func (h *Handler) encodeCursor(c *fiber.Ctx, last models.Book) string {
+	params := url.Values{}
+	for _, key := range cursorParams {
+		params[key] = queryValues(c, key)
+	}
+	after := sortKey(last, c.Query("sort"))
+	payload, _ := json.Marshal(pageToken{Params: params.Encode(), After: &after})
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(h.signCursor(payload))
+}
+
+func (h *Handler) signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, h.cursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// applyCursor checks the cursor of c and returns the book it continues
+// after, nil for the empty cursor of the first page. The sort and filters
+// the cursor carries are added to the query of c; sending one of them with
+// another value than the cursor has is an error.
+func (h *Handler) applyCursor(c *fiber.Ctx) (*models.Book, error) {
+	cursor := c.Query("cursor")
+	if cursor == "" {
+		return nil, nil
+	}
+	invalid := newError(ErrBadRequest, "invalid cursor")
+	encoded, signature, ok := strings.Cut(cursor, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil {
+		return nil, invalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, h.signCursor(payload)) {
+		return nil, invalid
+	}
+	var token pageToken
+	if err := json.Unmarshal(payload, &token); err != nil || token.After == nil {
+		return nil, invalid
+	}
+	params, err := url.ParseQuery(token.Params)
+	if err != nil {
+		return nil, invalid
+	}
+
+	args := c.Request().URI().QueryArgs()
+	for _, key := range cursorParams {
+		if args.Has(key) {
+			if !slices.Equal(queryValues(c, key), params[key]) {
+				return nil, newError(ErrBadRequest, key+" does not match the cursor")
+			}
+			continue
+		}
+		for _, v := range params[key] {
+			args.Add(key, v)
+		}
+	}
+	return token.After, nil
+}
+
+// queryValues returns every value of the query parameter key, nil if it is
+// not given.
+func queryValues(c *fiber.Ctx, key string) []string {
+	var values []string
+	for _, v := range c.Request().URI().QueryArgs().PeekMulti(key) {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+// sortKey returns the ID of b and the field spec sorts by, all a cursor
+// needs to find its place again.
+func sortKey(b models.Book, spec string) models.Book {
+	key := models.Book{ID: b.ID}
+	switch field, _ := strings.CutPrefix(spec, "-"); field {
+	case "title":
+		key.Title = b.Title
+	case "author":
+		key.Author = b.Author
+	case "year":
+		key.Year = b.Year
+	case "seq":
+		key.Seq = b.Seq
+	case "created_at":
+		key.CreatedAt = b.CreatedAt
+	case "updated_at":
+		key.UpdatedAt = b.UpdatedAt
+	case "views":
+		key.Views = b.Views
+	}
+	return key
+}
+
+// cursorSlice returns up to limit books ordered after the given one, or
+// from the first when after is nil, and whether more follow. books must be
+// sorted by order; unlike pages, cursors do not shift when books before
+// them are added or removed.
+func cursorSlice(books []models.Book, after *models.Book, limit int, order func(a, b models.Book) int) ([]models.Book, bool) {
+	start := 0
+	if after != nil {
+		start = sort.Search(len(books), func(i int) bool { return order(books[i], *after) > 0 })
+	}
+	end := min(start+limit, len(books))
+	page := books[start:end]
+	if page == nil {
+		page = []models.Book{}
+	}
+	return page, end < len(books)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"demo-golang/store"
+)
+
+// The kinds of error handlers answer with. ErrorHandler picks the status
+// from the kind; the message comes from newError.
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = store.ErrNotFound
+	ErrMethodNotAllowed   = errors.New("method not allowed")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrTooLarge           = errors.New("too large")
+	ErrValidation         = errors.New("validation failed")
+)
+
+var errorStatus = map[error]int{
+	ErrBadRequest:         http.StatusBadRequest,
+	ErrUnauthorized:       http.StatusUnauthorized,
+	ErrForbidden:          http.StatusForbidden,
+	ErrNotFound:           http.StatusNotFound,
+	ErrMethodNotAllowed:   http.StatusMethodNotAllowed,
+	ErrConflict:           http.StatusConflict,
+	ErrPreconditionFailed: http.StatusPreconditionFailed,
+	ErrTooLarge:           http.StatusRequestEntityTooLarge,
+	ErrValidation:         http.StatusUnprocessableEntity,
+}
+
+// apiError is an error of one of the kinds above with the message the
+// client gets.
+type apiError struct {
+	kind error
+	msg  string
+}
+
+func (e *apiError) Error() string { return e.msg }
+
+func (e *apiError) Unwrap() error { return e.kind }
+
+// newError returns an error of the given kind, e.g. ErrNotFound, that is
+// answered with msg.
+func newError(kind error, msg string) error {
+	return &apiError{kind: kind, msg: msg}
+}
+
+// errorCode is the machine-readable code of an error response with the
+// given status, e.g. "not_found". Clients can branch on it; unlike the
+// message it never changes.
+func errorCode(status int) string {
+	if status == http.StatusUnprocessableEntity {
+		return "validation_error"
+	}
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
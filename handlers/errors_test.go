@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestErrorCodes(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	tests := []struct {
+		method, target, body string
+		status               int
+		code                 string
+	}{
+		{http.MethodGet, "/api/books/" + uuid.NewString(), "", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/books/?page=x", "", http.StatusBadRequest, "bad_request"},
+		{http.MethodPost, "/api/books/", `{"author":"A"}`, http.StatusUnprocessableEntity, "validation_error"},
+		{http.MethodPut, "/api/books/", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodGet, "/nowhere", "", http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		status, body := do(t, app, tt.method, tt.target, tt.body)
+		var got ErrorResponse
+		decode(t, body, &got)
+		if status != tt.status || got.Code != tt.code || got.Error == "" {
+			t.Errorf("%s %s: status %d, body %s; want status %d and code %q", tt.method, tt.target, status, body, tt.status, tt.code)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := map[int]string{
+		http.StatusConflict:            "conflict",
+		http.StatusPreconditionFailed:  "precondition_failed",
+		http.StatusTooManyRequests:     "too_many_requests",
+		http.StatusInternalServerError: "internal_server_error",
+		599:                            "error",
+	}
+	for status, want := range tests {
+		if got := errorCode(status); got != want {
+			t.Errorf("errorCode(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
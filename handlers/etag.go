@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"demo-golang/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// bookETag is the entity tag of a stored book: a hash of its JSON form, so
+// it changes whenever any of its fields does. Views are left out, as
+// reading a book must not change its tag.
+func bookETag(b models.Book) string {
+	b.Views = 0
+	raw, _ := json.Marshal(b)
+	sum := sha256.Sum256(raw)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// cacheControl is the Cache-Control header sent with a book: without a
+// max age clients must revalidate their copy on every use.
+func cacheControl(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+	return "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists
+// etag. Weak tags are compared by their opaque part.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch refuses a write with 412 when the client sent If-Match and
+// the book changed since the client read it. It must be called inside the
+// store transaction that writes, so the book cannot change in between.
+func checkIfMatch(c *fiber.Ctx, current models.Book) error {
+	h := c.Get(fiber.HeaderIfMatch)
+	if h == "" || etagMatches(h, bookETag(current)) {
+		return nil
+	}
+	return newError(ErrPreconditionFailed, "book was modified since it was read; fetch it again")
+}
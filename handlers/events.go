@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"demo-golang/models"
+	"demo-golang/store"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// eventBuffer is how many events a client may fall behind by before it is
+// disconnected.
+const eventBuffer = 64
+
+// BookEvent is a message of the /api/books/events WebSocket.
+type BookEvent struct {
+	Type string      `json:"type" enums:"created,updated,deleted" example:"created"`
+	Book models.Book `json:"book"`
+}
+
+// eventHub fans the changes of the store out to at most max connected
+// WebSocket clients, each through its own buffered channel.
+type eventHub struct {
+	mu      sync.Mutex
+	max     int
+	clients map[chan BookEvent]struct{}
+}
+
+func newEventHub(max int) *eventHub {
+	return &eventHub{max: max, clients: map[chan BookEvent]struct{}{}}
+}
+
+// full reports whether the hub already has max clients.
+func (hub *eventHub) full() bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return len(hub.clients) >= hub.max
+}
+
+// admit answers 503 while the hub is full, before the connection is
+// upgraded.
+func (hub *eventHub) admit(c *fiber.Ctx) error {
+	if hub.full() {
+		return fiber.NewError(http.StatusServiceUnavailable, "too many event clients")
+	}
+	return c.Next()
+}
+
+// subscribe registers a client and returns the channel its events arrive
+// on, or false if the hub is full. The channel is closed when the client is
+// unsubscribed.
+func (hub *eventHub) subscribe() (chan BookEvent, bool) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if len(hub.clients) >= hub.max {
+		return nil, false
+	}
+	ch := make(chan BookEvent, eventBuffer)
+	hub.clients[ch] = struct{}{}
+	return ch, true
+}
+
+func (hub *eventHub) unsubscribe(ch chan BookEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if _, ok := hub.clients[ch]; ok {
+		delete(hub.clients, ch)
+		close(ch)
+	}
+}
+
+// publish sends changes to every client without waiting. A client whose
+// buffer is full is dropped rather than holding up the store's writers or
+// the other clients.
+func (hub *eventHub) publish(changes []store.Change) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for ch := range hub.clients {
+		if !trySend(ch, changes) {
+			delete(hub.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// trySend queues an event per change on ch, reporting false as soon as ch
+// is full.
+func trySend(ch chan BookEvent, changes []store.Change) bool {
+	for _, change := range changes {
+		select {
+		case ch <- BookEvent{Type: change.Kind, Book: change.Book}:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requireWebSocket answers plain HTTP requests to a WebSocket route with
+// 426.
+func requireWebSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	return c.Next()
+}
+
+// streamEvents godoc
+// @Summary Stream book changes
+// @Description WebSocket. Sends a JSON message for every book created, updated or deleted, in the order the changes happened. Restoring a book from the trash sends created. Clients that fall too far behind are disconnected. Connections beyond EVENTS_MAX_CLIENTS are refused with 503.
+// @Tags books
+// @Success 101 {object} BookEvent
+// @Failure 426 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /books/events [get]
+func (h *Handler) streamEvents(conn *websocket.Conn) {
+	events, ok := h.events.subscribe()
+	if !ok {
+		// Another client took the last slot after admit let this one in.
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many event clients")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return
+	}
+	defer h.events.unsubscribe(events)
+
+	// Clients are not expected to send anything; reading is how a closed
+	// connection is noticed.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"demo-golang/config"
+	"demo-golang/models"
+	"demo-golang/store"
+
+	"github.com/fasthttp/websocket"
+)
+
+func TestBookEventsWebSocket(t *testing.T) {
+	app, _ := newTestApp(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/api/books/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The handler subscribes after the upgrade response is sent; give it a
+	// moment before writing.
+	time.Sleep(50 * time.Millisecond)
+
+	status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", status, body)
+	}
+	var created models.Book
+	decode(t, body, &created)
+	if status, body := do(t, app, http.MethodPatch, "/api/books/"+created.ID, `{"year":2008}`); status != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", status, body)
+	}
+	if status, body := do(t, app, http.MethodDelete, "/api/books/"+created.ID, ""); status != http.StatusNoContent {
+		t.Fatalf("delete: status = %d: %s", status, body)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range []string{store.ChangeCreated, store.ChangeUpdated, store.ChangeDeleted} {
+		var ev BookEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			t.Fatalf("reading %s event: %v", want, err)
+		}
+		if ev.Type != want || ev.Book.ID != created.ID {
+			t.Errorf("event = %s %s, want %s %s", ev.Type, ev.Book.ID, want, created.ID)
+		}
+	}
+
+	status, _ = do(t, app, http.MethodGet, "/api/books/events", "")
+	if status != http.StatusUpgradeRequired {
+		t.Errorf("plain GET: status = %d, want %d", status, http.StatusUpgradeRequired)
+	}
+}
+
+func TestEventHubDropsSlowClients(t *testing.T) {
+	hub := newEventHub(1)
+	ch, _ := hub.subscribe()
+	changes := make([]store.Change, eventBuffer+1)
+	hub.publish(changes)
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != eventBuffer {
+		t.Errorf("slow client got %d events before being dropped, want %d", n, eventBuffer)
+	}
+	if len(hub.clients) != 0 {
+		t.Errorf("%d clients still subscribed, want 0", len(hub.clients))
+	}
+	hub.unsubscribe(ch)
+}
+
+func TestBookEventsMaxClients(t *testing.T) {
+	cfg := config.Default()
+	cfg.EventsMaxClients = 1
+	app, _ := newTestAppWithConfig(t, cfg)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	url := "ws://" + ln.Addr().String() + "/api/books/events"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the handler to subscribe.
+	time.Sleep(50 * time.Millisecond)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("second client connected, want it refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("second client: response = %v, want status %d", resp, http.StatusServiceUnavailable)
+	}
+
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("client after the first disconnected: %v", err)
+	}
+	conn.Close()
+}
+
+func TestEventHubMaxClients(t *testing.T) {
+	hub := newEventHub(2)
+	a, _ := hub.subscribe()
+	if _, ok := hub.subscribe(); !ok {
+		t.Fatal("second subscribe refused, want it accepted")
+	}
+	if _, ok := hub.subscribe(); ok {
+		t.Fatal("third subscribe accepted, want it refused")
+	}
+	hub.unsubscribe(a)
+	if _, ok := hub.subscribe(); !ok {
+		t.Error("subscribe after unsubscribe refused, want it accepted")
+	}
+}
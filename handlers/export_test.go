@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"demo-golang/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ndjsonLines splits an NDJSON body into its lines.
+func ndjsonLines(t *testing.T, body []byte) []json.RawMessage {
+	t.Helper()
+	var lines []json.RawMessage
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		if !json.Valid(sc.Bytes()) {
+			t.Fatalf("line is not JSON: %s", sc.Bytes())
+		}
+		lines = append(lines, json.RawMessage(slices.Clone(sc.Bytes())))
+	}
+	return lines
+}
+
+func TestExportNDJSON(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 3)
+
+	status, body := do(t, app, http.MethodGet, "/api/books/export.ndjson?sort=-title", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	var got []string
+	for _, line := range ndjsonLines(t, body) {
+		var b models.Book
+		decode(t, line, &b)
+		got = append(got, b.Title)
+	}
+	if want := []string{"Book 02", "Book 01", "Book 00"}; !slices.Equal(got, want) {
+		t.Errorf("titles = %v, want %v", got, want)
+	}
+}
+
+func TestExportNDJSONByCursor(t *testing.T) {
+	app, s := newTestApp(t)
+	seed(t, s, 5)
+	create(t, s, models.Book{Title: "Other", Author: "Someone Else"})
+
+	var got []string
+	chunks := 0
+	target := "/api/books/export.ndjson?author=Author&sort=-title&limit=3&cursor="
+	for target != "" {
+		status, body := do(t, app, http.MethodGet, target, "")
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want %d: %s", target, status, http.StatusOK, body)
+		}
+		lines := ndjsonLines(t, body)
+		if len(lines) == 0 || len(lines) > 4 {
+			t.Fatalf("GET %s: %d lines, want 1 to 4:\n%s", target, len(lines), body)
+		}
+		for _, line := range lines[:len(lines)-1] {
+			var b models.Book
+			decode(t, line, &b)
+			got = append(got, b.Title)
+		}
+		var meta map[string]*string
+		decode(t, lines[len(lines)-1], &meta)
+		next, ok := meta["next_cursor"]
+		if !ok || len(meta) != 1 {
+			t.Fatalf("GET %s: last line = %s, want only next_cursor", target, lines[len(lines)-1])
+		}
+		target = ""
+		if next != nil {
+			target = "/api/books/export.ndjson?limit=3&cursor=" + url.QueryEscape(*next)
+		}
+		if chunks++; chunks > 2 {
+			t.Fatal("chunked export does not end")
+		}
+	}
+
+	if want := []string{"Book 04", "Book 03", "Book 02", "Book 01", "Book 00"}; !slices.Equal(got, want) {
+		t.Errorf("titles over %d chunks = %v, want %v", chunks, got, want)
+	}
+	if chunks != 2 {
+		t.Errorf("export took %d chunks, want 2", chunks)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	app, s := newTestApp(t)
+	books := create(t, s,
+		models.Book{Title: `Design Patterns: Elements of "Reusable" Software`, Author: "Gamma, Helm, Johnson, Vlissides", Year: 1994},
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", ISBN: "9780134757599"},
+	)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/books/export.csv?sort=year", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get(fiber.HeaderContentType); got != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+	if got := resp.Header.Get(fiber.HeaderContentDisposition); !strings.Contains(got, `filename="books.csv"`) {
+		t.Errorf("Content-Disposition = %q, want an attachment named books.csv", got)
+	}
+	// A streamed body has no length known up front.
+	if resp.ContentLength != -1 || !slices.Contains(resp.TransferEncoding, "chunked") {
+		t.Errorf("Content-Length = %d, Transfer-Encoding = %v; want a chunked stream", resp.ContentLength, resp.TransferEncoding)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,title,author,year,isbn\n" +
+		books[1].ID + ",Refactoring,Martin Fowler,,9780134757599\n" +
+		books[0].ID + `,"Design Patterns: Elements of ""Reusable"" Software","Gamma, Helm, Johnson, Vlissides",1994,` + "\n"
+	if string(raw) != want {
+		t.Errorf("body =\n%s\nwant\n%s", raw, want)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(raw)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[2][1] != books[0].Title || rows[2][2] != books[0].Author {
+		t.Errorf("rows = %q, want the title and author back unchanged", rows)
+	}
+}
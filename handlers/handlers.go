@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"demo-golang/config"
+	"demo-golang/models"
+	"demo-golang/store"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Store is what the handlers need from the book store. *store.Store
+// implements it; tests can substitute their own.
+type Store interface {
+	Get(id string) (models.Book, bool)
+	// AddView counts a view of a book and returns its new view count.
+	AddView(id string) int64
+	List() ([]models.Book, int64)
+	// ChangesSince returns what changed after a store version; see
+	// store.Store.ChangesSince.
+	ChangesSince(since int64) (changed []models.Book, deleted []string, version int64, complete bool)
+	Len() int
+	Create(b models.Book) (models.Book, error)
+	Update(id string, fn func(b *models.Book) error) (models.Book, error)
+	Delete(id string) error
+	Purge(id string) error
+	Trash() []models.Book
+	// OnChange registers fn to be called, under the write lock, with the
+	// ID of every book written or removed.
+	OnChange(fn func(id string))
+	// Watch registers fn to be called with the changes of every write,
+	// after the write lock is released.
+	Watch(fn func(changes []store.Change))
+	// Tx runs fn under the store's write lock, for writes that must check
+	// other books first.
+	Tx(fn func(tx store.Tx) error) error
+}
+
+// Handler serves the book API from a store, shaped by cfg.
+type Handler struct {
+	store Store
+	cfg   config.Config
+	// enabledMethods records the methods registered through handle for
+	// each route path of the book group.
+	enabledMethods map[string][]string
+	// paths lists the route paths of the book group in registration order.
+	paths   []string
+	metrics *metrics
+	// cache holds marshaled books for getBookByID; nil when disabled.
+	cache *bookCache
+	// events broadcasts store changes to the /events WebSocket clients.
+	events *eventHub
+	// importClient fetches the files of importFromURL.
+	importClient *http.Client
+	// cursorKey signs the cursors of the book list.
+	cursorKey []byte
+	// responseRedact holds the lowercased RESPONSE_REDACT_FIELDS.
+	responseRedact map[string]bool
+}
+
+func New(s Store, cfg config.Config) *Handler {
+	h := &Handler{store: s, cfg: cfg, enabledMethods: map[string][]string{}, metrics: newMetrics(), events: newEventHub(cfg.EventsMaxClients)}
+	s.Watch(h.events.publish)
+	if cfg.BookCacheSize > 0 {
+		h.cache = newBookCache(cfg.BookCacheSize)
+		s.OnChange(h.cache.remove)
+	}
+	h.importClient = h.newImportClient()
+	h.cursorKey = []byte(cfg.CursorSecret)
+	if len(h.cursorKey) == 0 {
+		h.cursorKey = make([]byte, 32)
+		rand.Read(h.cursorKey)
+	}
+	h.responseRedact = make(map[string]bool, len(cfg.ResponseRedactFields))
+	for _, f := range cfg.ResponseRedactFields {
+		h.responseRedact[strings.ToLower(f)] = true
+	}
+	h.updateBookCount()
+	return h
+}
+
+// Register adds the book routes to books, the router for /api/books.
+func (h *Handler) Register(books fiber.Router) {
+	if h.cfg.APIKey != "" {
+		books.Use(requireAPIKey(h.cfg.APIKey))
+	} else {
+		log.Println("warning: API_KEY is not set, writes to the book routes are open to anyone")
+	}
+	h.handle(books, fiber.MethodGet, "/", h.allowQuery(listQueryParams...), h.getAllBooks)
+	h.handle(books, fiber.MethodGet, "/export.csv", h.allowQuery("sort", "author", "language", "tag", "withoutTag", "untagged", "year_min", "year_max"), h.exportBooksCSV)
+	h.handle(books, fiber.MethodGet, "/export.yaml", h.allowQuery("sort", "author", "language", "tag", "withoutTag", "untagged", "year_min", "year_max"), h.exportBooksYAML)
+	h.handle(books, fiber.MethodGet, "/export.ndjson", h.allowQuery("sort", "author", "language", "tag", "withoutTag", "untagged", "year_min", "year_max", "cursor", "limit"), h.exportBooksNDJSON)
+	h.handle(books, fiber.MethodGet, "/count", h.allowQuery("q", "year_min", "year_max"), h.countBooks)
+	h.handle(books, fiber.MethodGet, "/search", h.allowQuery("q", "page", "limit", "sort"), h.searchBooks)
+	h.handle(books, fiber.MethodGet, "/geojson", h.allowQuery(), h.getBooksGeoJSON)
+	h.handle(books, fiber.MethodGet, "/top-authors", h.allowQuery("limit"), h.getTopAuthors)
+	h.handle(books, fiber.MethodGet, "/tags", h.allowQuery(), h.getTags)
+	h.handle(books, fiber.MethodGet, "/stats", h.allowQuery(), h.getStats)
+	h.handle(books, fiber.MethodGet, "/trash", h.allowQuery(), h.getTrash)
+	h.handle(books, fiber.MethodGet, "/sample", h.allowQuery("size", "seed"), h.getSample)
+	h.handle(books, fiber.MethodGet, "/random", h.allowQuery("count", "author", "language", "tag", "withoutTag", "untagged", "year_min", "year_max"), h.getRandomBook)
+	h.handle(books, fiber.MethodGet, "/by-author/:author", h.allowQuery("page", "limit", "sort"), h.getBooksByAuthor)
+	h.handle(books, fiber.MethodGet, "/events", h.allowQuery(), requireWebSocket, h.events.admit, websocket.New(h.streamEvents))
+	h.handle(books, fiber.MethodGet, ":id", h.allowQuery(), h.getBookByID)
+	h.handle(books, fiber.MethodGet, ":id/related", h.allowQuery("limit", "depth"), h.relatedBooks)
+	h.handle(books, fiber.MethodPost, "/", h.allowQuery("force"), h.createBook)
+	h.handle(books, fiber.MethodPost, "/exists", h.allowQuery(), h.booksExist)
+	h.handle(books, fiber.MethodPost, "/import", h.allowQuery("mode", "onDuplicate"), h.importBooks)
+	h.handle(books, fiber.MethodPost, "/import/url", h.allowQuery("mode", "onDuplicate"), h.importFromURL)
+	h.handle(books, fiber.MethodPost, "/bulk", h.allowQuery(), h.bulkCreateBooks)
+	h.handle(books, fiber.MethodPatch, "/", h.allowQuery("all"), h.updateBooksByFilter)
+	h.handle(books, fiber.MethodPatch, "/bulk", h.allowQuery(), h.bulkUpdateBooks)
+	h.handle(books, fiber.MethodPost, "/batch-delete", h.allowQuery("hard"), h.batchDeleteBooks)
+	h.handle(books, fiber.MethodPatch, ":id", h.allowQuery(), h.updateBook)
+	h.handle(books, fiber.MethodPut, ":id", h.allowQuery(), h.replaceBook)
+	h.handle(books, fiber.MethodDelete, ":id", h.allowQuery("hard"), h.deleteBook)
+	h.handle(books, fiber.MethodPost, ":id/restore", h.allowQuery(), h.restoreBook)
+	h.handle(books, fiber.MethodPost, ":id/copies\\:adjust", h.allowQuery(), h.adjustCopies)
+	h.handle(books, fiber.MethodOptions, "/", h.optionsHandler(collectionCapabilities))
+	h.handle(books, fiber.MethodOptions, ":id", h.optionsHandler(itemCapabilities))
+	h.rejectOtherMethods(books)
+}
+
+// ErrorHandler renders every error as a JSON body with an error message and
+// a stable code. Errors of the kinds in errorStatus get their status from
+// it; book validation errors also list the failing fields.
+func (h *Handler) ErrorHandler(c *fiber.Ctx, err error) error {
+	// The server rejects oversized bodies before routing; tell the client
+	// what the cap is.
+	if err == fiber.ErrRequestEntityTooLarge {
+		return h.sendJSON(c, http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error: fmt.Sprintf("request body too large (max %d bytes)", h.cfg.BodyLimit),
+			Code:  errorCode(http.StatusRequestEntityTooLarge),
+		})
+	}
+	var verr *models.ValidationError
+	if errors.As(err, &verr) {
+		msg := "validation failed"
+		if err != error(verr) {
+			// Wrapping says which of several books failed.
+			msg = err.Error()
+		}
+		return h.sendJSON(c, http.StatusUnprocessableEntity, ValidationErrorResponse{
+			Error:  msg,
+			Code:   errorCode(http.StatusUnprocessableEntity),
+			Errors: verr.Fields,
+		})
+	}
+	for kind, status := range errorStatus {
+		if errors.Is(err, kind) {
+			return h.sendJSON(c, status, ErrorResponse{Error: err.Error(), Code: errorCode(status)})
+		}
+	}
+	if e, ok := err.(*fiber.Error); ok {
+		return h.sendJSON(c, e.Code, ErrorResponse{Error: e.Message, Code: errorCode(e.Code)})
+	}
+	log.Printf("internal error: %v (request_id=%s)", err, requestID(c))
+	return h.sendJSON(c, http.StatusInternalServerError, ErrorResponse{
+		Error: "internal server error",
+		Code:  errorCode(http.StatusInternalServerError),
+	})
+}
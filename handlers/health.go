@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Health is the liveness probe: it answers as long as the process serves
+// requests.
+func Health(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// Readiness is the readiness probe. Unlike Health, it fails until ready is
+// set, so traffic is only routed here once there is something to serve.
+func Readiness(ready *atomic.Bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !ready.Load() {
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"status": "starting"})
+		}
+		return c.JSON(fiber.Map{"status": "ready"})
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestProbesAnswerHead(t *testing.T) {
+	var ready atomic.Bool
+	app := fiber.New()
+	app.Get("/health", Health)
+	app.Get("/readyz", Readiness(&ready))
+
+	probe := func(method, target string) (int, string) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(method, target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := probe(http.MethodHead, "/health"); status != http.StatusOK || body != "" {
+		t.Errorf("HEAD /health = %d %q, want 200 with no body", status, body)
+	}
+	if status, body := probe(http.MethodGet, "/health"); status != http.StatusOK || body != `{"status":"ok"}` {
+		t.Errorf("GET /health = %d %q, want 200 with the ok body", status, body)
+	}
+	if status, body := probe(http.MethodHead, "/readyz"); status != http.StatusServiceUnavailable || body != "" {
+		t.Errorf("HEAD /readyz while starting = %d %q, want 503 with no body", status, body)
+	}
+	ready.Store(true)
+	if status, body := probe(http.MethodHead, "/readyz"); status != http.StatusOK || body != "" {
+		t.Errorf("HEAD /readyz once ready = %d %q, want 200 with no body", status, body)
+	}
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"demo-golang/models"
+	"demo-golang/store"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ImportError reports why one row of an imported file was skipped.
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportSummary counts the outcome of every row of an import. Imported is
+// Created plus Updated; Skipped counts the rows reported in Errors.
+type ImportSummary struct {
+	Imported   int           `json:"imported"`
+	Created    int           `json:"created"`
+	Updated    int           `json:"updated"`
+	Duplicates int           `json:"duplicates"`
+	Skipped    int           `json:"skipped"`
+	Errors     []ImportError `json:"errors"`
+}
+
+// The onDuplicate policies of an import.
+const (
+	OnDuplicateSkip   = "skip"
+	OnDuplicateUpdate = "update"
+	OnDuplicateCreate = "create"
+	OnDuplicateError  = "error"
+)
+
+// importOptions are the query parameters every import accepts.
+type importOptions struct {
+	mode        string
+	onDuplicate string
+}
+
+func parseImportOptions(c *fiber.Ctx) (importOptions, error) {
+	opts := importOptions{mode: c.Query("mode", "append"), onDuplicate: c.Query("onDuplicate", OnDuplicateSkip)}
+	if opts.mode != "append" && opts.mode != "replace" {
+		return opts, newError(ErrBadRequest, "mode must be append or replace")
+	}
+	switch opts.onDuplicate {
+	case OnDuplicateSkip, OnDuplicateUpdate, OnDuplicateCreate, OnDuplicateError:
+	default:
+		return opts, newError(ErrBadRequest, "onDuplicate must be skip, update, create or error")
+	}
+	return opts, nil
+}
+
+// importRow is a book read from an imported file with the line it starts
+// on. err is set when the row could not be turned into a book at all.
+type importRow struct {
+	line int
+	book models.Book
+	err  error
+}
+
+// importBooks godoc
+// @Summary Import books from a file
+// @Description Imports a CSV file with a header row, or a JSON array or YAML list of books. Invalid rows are skipped and reported by line. Rows matching a book, or an earlier row, by normalized title and author are handled per onDuplicate.
+// @Tags books
+// @Accept multipart/form-data
+// @Produce json,application/msgpack,application/xml
+// @Param file formData file true "CSV, JSON or YAML (application/x-yaml, .yaml or .yml) file"
+// @Param mode query string false "append (default) or replace, which deletes every book first"
+// @Param onDuplicate query string false "What to do with a row with the same normalized title and author as a book: skip (default), update it like PUT, create another, or error" Enums(skip,update,create,error)
+// @Success 200 {object} ImportSummary
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/import [post]
+func (h *Handler) importBooks(c *fiber.Ctx) error {
+	opts, err := parseImportOptions(c)
+	if err != nil {
+		return err
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return newError(ErrBadRequest, "file is required")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	var format string
+	ct := strings.ToLower(fh.Header.Get(fiber.HeaderContentType))
+	ext := strings.ToLower(filepath.Ext(fh.Filename))
+	switch {
+	case strings.HasPrefix(ct, "text/csv") || ext == ".csv":
+		format = "csv"
+	case strings.HasPrefix(ct, fiber.MIMEApplicationJSON) || ext == ".json":
+		format = "json"
+	case strings.HasPrefix(ct, mimeApplicationYAML) || strings.HasPrefix(ct, "application/yaml") ||
+		ext == ".yaml" || ext == ".yml":
+		format = "yaml"
+	default:
+		return newError(ErrBadRequest, "file must be CSV, JSON or YAML")
+	}
+	return h.importRaw(c, raw, format, opts)
+}
+
+// importRaw parses raw in the given format, csv, json or yaml, imports the rows
+// and answers with the summary.
+func (h *Handler) importRaw(c *fiber.Ctx, raw []byte, format string, opts importOptions) error {
+	var rows []importRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = parseImportCSV(raw)
+	case "json":
+		rows, err = parseImportJSON(raw)
+	case "yaml":
+		rows, err = parseImportYAML(raw)
+	}
+	if err != nil {
+		return newError(ErrBadRequest, err.Error())
+	}
+
+	forEachRow(len(rows), h.cfg.ImportWorkers, func(i int) {
+		r := &rows[i]
+		if r.err == nil {
+			h.fillGeneratedTitle(&r.book)
+			r.err = models.ValidateBookPayload(&r.book)
+		}
+	})
+
+	summary := ImportSummary{Errors: []ImportError{}}
+	valid := rows[:0]
+	for _, r := range rows {
+		if r.err != nil {
+			summary.Errors = append(summary.Errors, ImportError{Line: r.line, Error: r.err.Error()})
+			continue
+		}
+		valid = append(valid, r)
+	}
+
+	err = h.store.Tx(func(tx store.Tx) error {
+		if opts.mode == "replace" {
+			for _, b := range tx.List() {
+				tx.Delete(b.ID)
+			}
+		}
+		// Index the books by dedupe key once rather than scanning the store
+		// for every row.
+		ids := make(map[BookKey]string)
+		for _, b := range tx.List() {
+			ids[dedupeKey(b.Title, b.Author)] = b.ID
+		}
+		for _, r := range valid {
+			key := dedupeKey(r.book.Title, r.book.Author)
+			id, dup := ids[key]
+			update := dup && opts.onDuplicate == OnDuplicateUpdate
+			if dup {
+				switch opts.onDuplicate {
+				case OnDuplicateSkip:
+					summary.Duplicates++
+					continue
+				case OnDuplicateError:
+					summary.Errors = append(summary.Errors, ImportError{Line: r.line, Error: "duplicate of book " + id})
+					continue
+				case OnDuplicateUpdate:
+					r.book.ID = id
+				}
+			}
+			if err := h.checkUniqueTitle(tx, r.book); err != nil {
+				summary.Errors = append(summary.Errors, ImportError{Line: r.line, Error: err.Error()})
+				continue
+			}
+			var saved models.Book
+			if update {
+				saved = tx.Save(r.book)
+				summary.Updated++
+			} else {
+				saved = tx.Create(r.book)
+				summary.Created++
+			}
+			ids[key] = saved.ID
+			summary.Imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(summary.Errors, func(i, j int) bool { return summary.Errors[i].Line < summary.Errors[j].Line })
+	summary.Skipped = len(summary.Errors)
+	return h.sendJSON(c, http.StatusOK, summary)
+}
+
+// forEachRow calls fn with every index below n, spread over up to workers
+// goroutines that each take a contiguous run of indices, and waits for
+// them. fn must only touch the row at its index, so results stay in row
+// order. Only validation runs in parallel: the store takes one write lock
+// per import anyway, so that an import is applied all at once.
+func forEachRow(n, workers int, fn func(i int)) {
+	workers = min(max(workers, 1), n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	per := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += per {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				fn(i)
+			}
+		}(start, min(start+per, n))
+	}
+	wg.Wait()
+}
+
+// parseImportCSV reads books from CSV whose header row names the columns.
+// Columns other than the book fields below are ignored.
+func parseImportCSV(raw []byte) ([]importRow, error) {
+	r := csv.NewReader(bytes.NewReader(raw))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var rows []importRow
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		line, _ := r.FieldPos(0)
+		get := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		b := models.Book{
+			Title:         get("title"),
+			Author:        get("author"),
+			ISBN:          get("isbn"),
+			Language:      get("language"),
+			PublishedCity: get("published_city"),
+		}
+		row := importRow{line: line, book: b}
+		for _, field := range []struct {
+			name string
+			dst  *int
+		}{{"year", &row.book.Year}, {"copies", &row.book.Copies}} {
+			if v := get(field.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil && row.err == nil {
+					row.err = fmt.Errorf("%s must be an integer, got %q", field.name, v)
+				}
+				*field.dst = n
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseImportJSON reads books from a JSON array. Each item is reported by
+// the line its opening brace is on.
+func parseImportJSON(raw []byte) ([]importRow, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("JSON file must contain an array of books")
+	}
+	var rows []importRow
+	for dec.More() {
+		start := int(dec.InputOffset())
+		for start < len(raw) && strings.ContainsRune(" \t\r\n,", rune(raw[start])) {
+			start++
+		}
+		line := bytes.Count(raw[:start], []byte("\n")) + 1
+
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return nil, fmt.Errorf("invalid JSON on line %d: %v", line, err)
+		}
+		row := importRow{line: line}
+		if err := json.Unmarshal(item, &row.book); err != nil {
+			row.err = err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseImportYAML reads books from a YAML list, whose items use the JSON
+// field names. Each item is reported by the line it starts on.
+func parseImportYAML(raw []byte) ([]importRow, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.SequenceNode {
+		return nil, errors.New("YAML file must contain a list of books")
+	}
+	var rows []importRow
+	for _, item := range doc.Content[0].Content {
+		row := importRow{line: item.Line}
+		var v map[string]interface{}
+		if err := item.Decode(&v); err != nil {
+			row.err = err
+		} else if raw, err := json.Marshal(v); err != nil {
+			row.err = err
+		} else if err := json.Unmarshal(raw, &row.book); err != nil {
+			row.err = err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ImportURLRequest names a file for the server to fetch and import.
+type ImportURLRequest struct {
+	URL    string `json:"url" example:"https://data.example.com/books.csv"`
+	Format string `json:"format" enums:"csv,json,yaml" example:"csv"`
+}
+
+// importFromURL godoc
+// @Summary Import books from a URL
+// @Description Fetches a CSV, JSON or YAML file and imports it like /books/import. Only hosts listed in IMPORT_URL_HOSTS may be fetched, redirects included; the fetch is bounded by IMPORT_URL_TIMEOUT and BODY_LIMIT.
+// @Tags books
+// @Accept json,application/msgpack
+// @Produce json,application/msgpack,application/xml
+// @Param request body ImportURLRequest true "File to import"
+// @Param mode query string false "append (default) or replace, which deletes every book first"
+// @Param onDuplicate query string false "What to do with a row with the same normalized title and author as a book: skip (default), update it like PUT, create another, or error" Enums(skip,update,create,error)
+// @Success 200 {object} ImportSummary
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /books/import/url [post]
+func (h *Handler) importFromURL(c *fiber.Ctx) error {
+	opts, err := parseImportOptions(c)
+	if err != nil {
+		return err
+	}
+	var payload ImportURLRequest
+	if err := parseBody(c, &payload); err != nil {
+		return newError(ErrBadRequest, "invalid request body")
+	}
+	format := strings.ToLower(strings.TrimSpace(payload.Format))
+	if format != "csv" && format != "json" && format != "yaml" {
+		return newError(ErrBadRequest, "format must be csv, json or yaml")
+	}
+	u, err := url.Parse(strings.TrimSpace(payload.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newError(ErrBadRequest, "url must be an absolute http or https URL")
+	}
+	if !h.importHostAllowed(u) {
+		return newError(ErrForbidden, fmt.Sprintf("host %q is not allowed; see IMPORT_URL_HOSTS", u.Hostname()))
+	}
+
+	req, err := http.NewRequestWithContext(c.UserContext(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return newError(ErrBadRequest, "url must be an absolute http or https URL")
+	}
+	resp, err := h.importClient.Do(req)
+	if err != nil {
+		return newError(ErrBadRequest, "fetching url: "+err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newError(ErrBadRequest, fmt.Sprintf("fetching url: status %d", resp.StatusCode))
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, int64(h.cfg.BodyLimit)+1))
+	if err != nil {
+		return newError(ErrBadRequest, "fetching url: "+err.Error())
+	}
+	if len(raw) > h.cfg.BodyLimit {
+		return newError(ErrTooLarge, fmt.Sprintf("file is larger than %d bytes", h.cfg.BodyLimit))
+	}
+	return h.importRaw(c, raw, format, opts)
+}
+
+// importHostAllowed reports whether u is on a host listed in
+// IMPORT_URL_HOSTS, compared without the port.
+func (h *Handler) importHostAllowed(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range h.cfg.ImportURLHosts {
+		if strings.ToLower(allowed) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// newImportClient returns the client importFromURL fetches with. It
+// refuses redirects to hosts that are not allowed, so an allowed host
+// cannot forward the server elsewhere.
+func (h *Handler) newImportClient() *http.Client {
+	return &http.Client{
+		Timeout: h.cfg.ImportURLTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			if !h.importHostAllowed(req.URL) {
+				return fmt.Errorf("redirect to host %q is not allowed", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"demo-golang/config"
+	"demo-golang/models"
+	"demo-golang/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const importCSV = `title,author,year
+Refactoring,Martin Fowler,1999
+,Nobody,2000
+Clean Code,Robert C. Martin,2008
+`
+
+// newImportServer serves importCSV at /books.csv and redirects /elsewhere to
+// target.
+func newImportServer(t *testing.T, target string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/books.csv":
+			w.Header().Set("Content-Type", "text/csv")
+			fmt.Fprint(w, importCSV)
+		case "/elsewhere":
+			http.Redirect(w, r, target, http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestImportFromURL(t *testing.T) {
+	srv := newImportServer(t, "http://localhost:1/books.csv")
+	cfg := config.Default()
+	cfg.ImportURLHosts = []string{"127.0.0.1"}
+	app, s := newTestAppWithConfig(t, cfg)
+
+	status, body := do(t, app, http.MethodPost, "/api/books/import/url",
+		fmt.Sprintf(`{"url":%q,"format":"csv"}`, srv.URL+"/books.csv"))
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, body)
+	}
+	var summary ImportSummary
+	decode(t, body, &summary)
+	if summary.Imported != 2 || summary.Skipped != 1 || len(summary.Errors) != 1 || summary.Errors[0].Line != 3 {
+		t.Errorf("summary = %+v, want 2 imported and line 3 skipped", summary)
+	}
+	if got := titles(t, app, "/api/books/?sort=title"); !slices.Equal(got, []string{"Clean Code", "Refactoring"}) {
+		t.Errorf("titles = %q after import", got)
+	}
+
+	for _, tc := range []struct {
+		name, body string
+		want       int
+	}{
+		{"host not allowed", `{"url":"http://localhost/books.csv","format":"csv"}`, http.StatusForbidden},
+		{"redirect to host not allowed", fmt.Sprintf(`{"url":%q,"format":"csv"}`, srv.URL+"/elsewhere"), http.StatusBadRequest},
+		{"not found", fmt.Sprintf(`{"url":%q,"format":"csv"}`, srv.URL+"/missing.csv"), http.StatusBadRequest},
+		{"scheme", `{"url":"file:///etc/passwd","format":"csv"}`, http.StatusBadRequest},
+		{"format", fmt.Sprintf(`{"url":%q,"format":"xml"}`, srv.URL+"/books.csv"), http.StatusBadRequest},
+	} {
+		if status, body := do(t, app, http.MethodPost, "/api/books/import/url", tc.body); status != tc.want {
+			t.Errorf("%s: status = %d, want %d: %s", tc.name, status, tc.want, body)
+		}
+	}
+	if n := s.Len(); n != 2 {
+		t.Errorf("store holds %d books after the failed imports, want 2", n)
+	}
+}
+
+func TestImportFromURLLimitsSize(t *testing.T) {
+	srv := newImportServer(t, "")
+	cfg := config.Default()
+	cfg.ImportURLHosts = []string{"127.0.0.1"}
+	cfg.BodyLimit = len(importCSV) - 1
+	app, s := newTestAppWithConfig(t, cfg)
+
+	status, body := do(t, app, http.MethodPost, "/api/books/import/url",
+		fmt.Sprintf(`{"url":%q,"format":"csv"}`, srv.URL+"/books.csv"))
+	if status != http.StatusRequestEntityTooLarge || !strings.Contains(string(body), "larger") {
+		t.Errorf("status = %d, body = %s; want %d", status, body, http.StatusRequestEntityTooLarge)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("store holds %d books, want none imported", n)
+	}
+}
+
+// importFile posts content to /api/books/import as a file named name.
+func importFile(tb testing.TB, app *fiber.App, target, name, content string) (int, []byte) {
+	tb.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	io.WriteString(part, content)
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &buf)
+	req.Header.Set(fiber.HeaderContentType, w.FormDataContentType())
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+// largeImportCSV returns a CSV of n books in which every tenth row, from
+// line 2 on, is invalid.
+func largeImportCSV(n int) string {
+	var b strings.Builder
+	b.WriteString("title,author,year\n")
+	for i := 0; i < n; i++ {
+		year := 1990 + i%30
+		if i%10 == 0 {
+			year = 500
+		}
+		fmt.Fprintf(&b, "Book %d,Author %d,%d\n", i, i%50, year)
+	}
+	return b.String()
+}
+
+func TestImportWithWorkersKeepsRowOrder(t *testing.T) {
+	const n = 500
+	csv := largeImportCSV(n)
+	var summaries []string
+	for _, workers := range []int{1, 4} {
+		cfg := config.Default()
+		cfg.ImportWorkers = workers
+		app, s := newTestAppWithConfig(t, cfg)
+
+		status, body := importFile(t, app, "/api/books/import", "books.csv", csv)
+		if status != http.StatusOK {
+			t.Fatalf("workers=%d: status = %d: %s", workers, status, body)
+		}
+		var summary ImportSummary
+		decode(t, body, &summary)
+		if summary.Imported != n-n/10 || s.Len() != n-n/10 || summary.Skipped != n/10 {
+			t.Errorf("workers=%d: imported %d, skipped %d, stored %d; want %d, %d, %d",
+				workers, summary.Imported, summary.Skipped, s.Len(), n-n/10, n/10, n-n/10)
+		}
+		for i, e := range summary.Errors {
+			if want := 2 + 10*i; e.Line != want {
+				t.Fatalf("workers=%d: error %d is for line %d, want %d", workers, i, e.Line, want)
+			}
+		}
+		// Catalog numbers follow the file, whatever validated first.
+		books, _ := s.List()
+		slices.SortFunc(books, func(a, b models.Book) int { return int(a.Seq - b.Seq) })
+		if books[0].Title != "Book 1" || books[len(books)-1].Title != fmt.Sprintf("Book %d", n-1) {
+			t.Errorf("workers=%d: first and last by seq are %q and %q", workers, books[0].Title, books[len(books)-1].Title)
+		}
+		summaries = append(summaries, string(body))
+	}
+	if summaries[0] != summaries[1] {
+		t.Errorf("summaries differ between 1 and 4 workers:\n%s\n%s", summaries[0], summaries[1])
+	}
+}
+
+func TestBulkCreateWithWorkersReportsFirstInvalidBook(t *testing.T) {
+	cfg := config.Default()
+	cfg.ImportWorkers = 4
+	app, s := newTestAppWithConfig(t, cfg)
+
+	books := make([]string, 40)
+	for i := range books {
+		books[i] = fmt.Sprintf(`{"title":"Book %d","author":"Author"}`, i)
+	}
+	books[13] = `{"title":"","author":"Author"}`
+	books[31] = `{"title":"Book","author":""}`
+	status, body := do(t, app, http.MethodPost, "/api/books/bulk", "["+strings.Join(books, ",")+"]")
+	if status != http.StatusBadRequest || !strings.Contains(string(body), "book 13:") {
+		t.Errorf("status = %d, body = %s; want %d for book 13", status, body, http.StatusBadRequest)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("store holds %d books, want none created", n)
+	}
+}
+
+func BenchmarkImport(b *testing.B) {
+	csv := largeImportCSV(5000)
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			cfg := config.Default()
+			cfg.ImportWorkers = workers
+			cfg.BodyLimit = 1 << 24
+			b.SetBytes(int64(len(csv)))
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				h := New(store.New("", 1), cfg)
+				app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler, BodyLimit: cfg.BodyLimit})
+				h.Register(app.Group("/api").Group("/books"))
+				b.StartTimer()
+				if status, body := importFile(b, app, "/api/books/import", "books.csv", csv); status != http.StatusOK {
+					b.Fatalf("status = %d: %s", status, body)
+				}
+			}
+		})
+	}
+}
+
+func TestImportOnDuplicate(t *testing.T) {
+	// Line 2 duplicates the stored book, line 4 duplicates line 3.
+	const csv = `title,author,year
+ REFACTORING ,martin fowler,2018
+Clean Code,Robert C. Martin,2008
+clean code,Robert C. Martin,2009
+`
+	for _, tc := range []struct {
+		policy                                string
+		created, updated, duplicates, skipped int
+		books                                 int
+		refactoringYear                       int
+	}{
+		{"", 1, 0, 2, 0, 2, 1999},
+		{"skip", 1, 0, 2, 0, 2, 1999},
+		{"update", 1, 2, 0, 0, 2, 2018},
+		{"create", 3, 0, 0, 0, 4, 1999},
+		{"error", 1, 0, 0, 2, 2, 1999},
+	} {
+		app, s := newTestApp(t)
+		stored := create(t, s, models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999})[0]
+
+		target := "/api/books/import"
+		if tc.policy != "" {
+			target += "?onDuplicate=" + tc.policy
+		}
+		status, body := importFile(t, app, target, "books.csv", csv)
+		if status != http.StatusOK {
+			t.Fatalf("%q: status = %d: %s", tc.policy, status, body)
+		}
+		var got ImportSummary
+		decode(t, body, &got)
+		if got.Created != tc.created || got.Updated != tc.updated || got.Duplicates != tc.duplicates ||
+			got.Skipped != tc.skipped || got.Imported != tc.created+tc.updated {
+			t.Errorf("%q: summary = %+v, want %d created, %d updated, %d duplicates, %d skipped",
+				tc.policy, got, tc.created, tc.updated, tc.duplicates, tc.skipped)
+		}
+		if n := s.Len(); n != tc.books {
+			t.Errorf("%q: store holds %d books, want %d", tc.policy, n, tc.books)
+		}
+		if b, _ := s.Get(stored.ID); b.Year != tc.refactoringYear {
+			t.Errorf("%q: stored book has year %d, want %d", tc.policy, b.Year, tc.refactoringYear)
+		}
+		if tc.policy == "error" && (len(got.Errors) != 2 || got.Errors[0].Line != 2 || got.Errors[1].Line != 4 ||
+			!strings.Contains(got.Errors[0].Error, stored.ID)) {
+			t.Errorf("%q: errors = %+v, want lines 2 and 4 naming the duplicated book", tc.policy, got.Errors)
+		}
+	}
+
+	app, _ := newTestApp(t)
+	if status, _ := importFile(t, app, "/api/books/import?onDuplicate=merge", "books.csv", csv); status != http.StatusBadRequest {
+		t.Errorf("unknown policy: status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestYAMLExportImportRoundTrip(t *testing.T) {
+	app, s := newTestApp(t)
+	lat, lng := -6.2, 106.8
+	create(t, s,
+		models.Book{Title: "Laskar Pelangi", Author: "Andrea Hirata", Year: 2005, Language: "id", Tags: []string{"fiction"},
+			PublishedCity: "Yogyakarta", Latitude: &lat, Longitude: &lng, Copies: 3},
+		models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999, ISBN: "9780201485677"},
+		models.Book{Title: "Untitled notes", Author: "Anonymous"},
+	)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/books/export.yaml?sort=title", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(fiber.HeaderContentType) != mimeApplicationYAML {
+		t.Fatalf("export: status = %d, Content-Type = %q: %s", resp.StatusCode, resp.Header.Get(fiber.HeaderContentType), exported)
+	}
+	if !strings.Contains(string(exported), "published_city: Yogyakarta") {
+		t.Errorf("export does not use the JSON field names:\n%s", exported)
+	}
+
+	imported, s2 := newTestApp(t)
+	status, body := importFile(t, imported, "/api/books/import", "books.yaml", string(exported))
+	if status != http.StatusOK {
+		t.Fatalf("import: status = %d: %s", status, body)
+	}
+	var summary ImportSummary
+	decode(t, body, &summary)
+	if summary.Created != 3 || summary.Skipped != 0 {
+		t.Errorf("import summary = %+v, want 3 created", summary)
+	}
+
+	// Identity and timestamps are the new store's; the content must match.
+	content := func(st *store.Store) []string {
+		books, _ := st.List()
+		out := make([]string, len(books))
+		for i, b := range books {
+			b.ID, b.Seq, b.Version, b.CreatedAt, b.UpdatedAt = "", 0, 0, time.Time{}, time.Time{}
+			raw, err := json.Marshal(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[i] = string(raw)
+		}
+		slices.Sort(out)
+		return out
+	}
+	if got, want := content(s2), content(s); !slices.Equal(got, want) {
+		t.Errorf("imported books differ:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestImportYAMLReportsLines(t *testing.T) {
+	app, s := newTestApp(t)
+	const file = `- title: Refactoring
+  author: Martin Fowler
+- title: Ancient
+  author: Unknown
+  year: 500
+- title: Clean Code
+  author: Robert C. Martin
+  year: not a year
+`
+	status, body := importFile(t, app, "/api/books/import", "books.yml", file)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, body)
+	}
+	var summary ImportSummary
+	decode(t, body, &summary)
+	if summary.Created != 1 || len(summary.Errors) != 2 || summary.Errors[0].Line != 3 || summary.Errors[1].Line != 6 {
+		t.Errorf("summary = %+v, want 1 created and lines 3 and 6 reported", summary)
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("store holds %d books, want 1", n)
+	}
+
+	if status, _ := importFile(t, app, "/api/books/import", "books.yaml", "title: not a list\n"); status != http.StatusBadRequest {
+		t.Errorf("not a list: status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
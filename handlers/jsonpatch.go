@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"demo-golang/models"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gofiber/fiber/v2"
+)
+
+// mimeApplicationJSONPatch is the media type of JSON Patch request bodies
+// on PATCH /api/books/:id.
+const mimeApplicationJSONPatch = "application/json-patch+json"
+
+// readOnlyBookFields are the book fields the server maintains. A JSON Patch
+// may test them, e.g. /version for optimistic concurrency, but not change
+// them.
+var readOnlyBookFields = map[string]bool{
+	"id":             true,
+	"seq":            true,
+	"version":        true,
+	"created_at":     true,
+	"updated_at":     true,
+	"deleted_at":     true,
+	"generatedTitle": true,
+	"views":          true,
+}
+
+// isJSONPatch reports whether the request body is a JSON Patch document.
+func isJSONPatch(c *fiber.Ctx) bool {
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(c.Get(fiber.HeaderContentType), ";")[0]))
+	return ct == mimeApplicationJSONPatch
+}
+
+// parseJSONPatch decodes a JSON Patch (RFC 6902) body, allowing only the
+// add, replace, remove and test operations and refusing changes to
+// read-only fields.
+func parseJSONPatch(c *fiber.Ctx) (jsonpatch.Patch, error) {
+	patch, err := jsonpatch.DecodePatch(c.Body())
+	if err != nil {
+		return nil, newError(ErrBadRequest, "invalid JSON Patch body")
+	}
+	for i, op := range patch {
+		path, err := op.Path()
+		if err != nil {
+			return nil, newError(ErrBadRequest, fmt.Sprintf("operation %d: %v", i, err))
+		}
+		switch op.Kind() {
+		case "test":
+		case "add", "replace", "remove":
+			field := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+			if readOnlyBookFields[field] {
+				return nil, newError(ErrValidation, fmt.Sprintf("operation %d: %s is read-only", i, field))
+			}
+		default:
+			return nil, newError(ErrValidation, fmt.Sprintf("operation %d: unsupported op %q", i, op.Kind()))
+		}
+	}
+	return patch, nil
+}
+
+// applyJSONPatch applies patch to b one operation at a time, so a failed
+// test can be told apart from an operation that cannot be applied: the
+// first answers 409, the second 422.
+func applyJSONPatch(b models.Book, patch jsonpatch.Patch) (models.Book, error) {
+	doc, err := json.Marshal(models.BookFields(b))
+	if err != nil {
+		return b, err
+	}
+	for i, op := range patch {
+		if doc, err = (jsonpatch.Patch{op}).Apply(doc); err != nil {
+			if op.Kind() == "test" {
+				return b, newError(ErrConflict, fmt.Sprintf("operation %d: test failed", i))
+			}
+			return b, newError(ErrValidation, fmt.Sprintf("operation %d: %v", i, err))
+		}
+	}
+
+	var patched models.Book
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		return b, newError(ErrValidation, "patched book is invalid: "+err.Error())
+	}
+	patched.ID, patched.Seq, patched.Version = b.ID, b.Seq, b.Version
+	patched.CreatedAt, patched.UpdatedAt, patched.DeletedAt = b.CreatedAt, b.UpdatedAt, b.DeletedAt
+	patched.GeneratedTitle = b.GeneratedTitle && patched.Title == b.Title
+	return patched, nil
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"demo-golang/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func doJSONPatch(t *testing.T, app *fiber.App, id, body string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPatch, "/api/books/"+id, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, mimeApplicationJSONPatch)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func TestJSONPatchAppliesOperations(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999, Language: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := doJSONPatch(t, app, b.ID, `[
+		{"op":"test","path":"/version","value":1},
+		{"op":"replace","path":"/year","value":2018},
+		{"op":"remove","path":"/language"},
+		{"op":"add","path":"/tags","value":["refactoring"]}
+	]`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, body)
+	}
+	got, _ := s.Get(b.ID)
+	if got.Year != 2018 || got.Language != "" || len(got.Tags) != 1 || got.Tags[0] != "refactoring" {
+		t.Errorf("got %+v, want year 2018, no language and tag refactoring", got)
+	}
+	if got.Title != b.Title || got.CreatedAt != b.CreatedAt {
+		t.Errorf("untouched fields changed: got %+v, had %+v", got, b)
+	}
+}
+
+func TestJSONPatchFailedTestConflicts(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler", Year: 1999})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := doJSONPatch(t, app, b.ID, `[
+		{"op":"test","path":"/year","value":2000},
+		{"op":"replace","path":"/year","value":2018}
+	]`)
+	if status != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusConflict, body)
+	}
+	if got, _ := s.Get(b.ID); got.Year != 1999 {
+		t.Errorf("year = %d, want it unchanged", got.Year)
+	}
+}
+
+func TestJSONPatchRejectsInvalidOperations(t *testing.T) {
+	app, s := newTestApp(t)
+	b, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"op":"replace"}`, http.StatusBadRequest},
+		{`[{"op":"replace","path":"/id","value":"x"}]`, http.StatusUnprocessableEntity},
+		{`[{"op":"remove","path":"/version"}]`, http.StatusUnprocessableEntity},
+		{`[{"op":"move","from":"/title","path":"/author"}]`, http.StatusUnprocessableEntity},
+		{`[{"op":"remove","path":"/title"}]`, http.StatusUnprocessableEntity},
+		{`[{"op":"replace","path":"/year","value":"soon"}]`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if status, resp := doJSONPatch(t, app, b.ID, tt.body); status != tt.want {
+			t.Errorf("PATCH %s: status = %d, want %d: %s", tt.body, status, tt.want, resp)
+		}
+	}
+	if got, _ := s.Get(b.ID); got.Title != b.Title || got.Version != b.Version {
+		t.Errorf("book changed: got %+v, had %+v", got, b)
+	}
+}
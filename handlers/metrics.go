@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the Prometheus metrics of one Handler. Each Handler has its
+// own registry, so several can run in one process, as they do in tests.
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	books    prometheus.Gauge
+	// cacheHits and cacheMisses count lookups in the book cache.
+	cacheHits   prometheus.Counter
+	cacheMisses prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by method and status code.",
+		}, []string{"method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		books: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "books_total",
+			Help: "Books in the catalog.",
+		}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "book_cache_hits_total",
+			Help: "Single book reads served from the book cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "book_cache_misses_total",
+			Help: "Single book reads the book cache had to marshal.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.books,
+		m.cacheHits,
+		m.cacheMisses,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// CountRequests records the method, status and duration of every request
+// that reaches it, including those no route matches. Use it on the app
+// before RenderErrors and any routes.
+func (h *Handler) CountRequests(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+	// Fiber reuses the buffer behind c.Method once the request is done, and
+	// the label values outlive it.
+	method := strings.Clone(c.Method())
+	h.metrics.requests.WithLabelValues(method, strconv.Itoa(c.Response().StatusCode())).Inc()
+	h.metrics.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// Metrics serves the metrics in the Prometheus text format.
+func (h *Handler) Metrics() fiber.Handler {
+	serve := adaptor.HTTPHandler(promhttp.HandlerFor(h.metrics.registry, promhttp.HandlerOpts{}))
+	return func(c *fiber.Ctx) error {
+		// The store may have been filled, e.g. loaded from disk, without
+		// going through a write handler.
+		h.updateBookCount()
+		return serve(c)
+	}
+}
+
+// updateBookCount sets the catalog size gauge from the store.
+func (h *Handler) updateBookCount() {
+	h.metrics.books.Set(float64(h.store.Len()))
+}
+
+// countBooksAfter updates the catalog size gauge once a write handler has
+// run.
+func (h *Handler) countBooksAfter(c *fiber.Ctx) error {
+	defer h.updateBookCount()
+	return c.Next()
+}
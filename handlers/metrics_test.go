@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"demo-golang/config"
+	"demo-golang/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMetrics(t *testing.T) {
+	h := New(store.New("", 1), config.Default())
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+	app.Use(h.CountRequests)
+	app.Use(RenderErrors)
+	app.Get("/metrics", h.Metrics())
+	h.Register(app.Group("/api").Group("/books"))
+
+	if status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin"}`); status != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", status, body)
+	}
+	do(t, app, http.MethodGet, "/nowhere", "")
+
+	status, body := do(t, app, http.MethodGet, "/metrics", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="POST",status="201"} 1`,
+		`http_requests_total{method="GET",status="404"} 1`,
+		`http_request_duration_seconds_count{method="POST"} 1`,
+		"books_total 1",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
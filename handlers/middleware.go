@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"demo-golang/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// CanonicalHost rejects or redirects requests whose Host header is not host,
+// depending on policy. The port of the Host header is ignored. Health
+// checks are always let through so probes that address the pod directly
+// keep working.
+func CanonicalHost(host, policy string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Path() == "/health" || c.Path() == "/readyz" || strings.EqualFold(hostWithoutPort(c.Hostname()), host) {
+			return c.Next()
+		}
+		if policy == config.HostPolicyRedirect {
+			return c.Redirect(c.Protocol()+"://"+host+c.OriginalURL(), http.StatusMovedPermanently)
+		}
+		return fiber.NewError(http.StatusMisdirectedRequest, "misdirected request")
+	}
+}
+
+// hostWithoutPort strips the port, if any, from a Host header value,
+// including a bracketed IPv6 address.
+func hostWithoutPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+}
+
+// CORS lets browsers on cfg.CORSOrigins call the API and read the headers
+// clients page, cache and pace themselves by. Use it before anything that
+// can fail a request, so browsers can read error responses too.
+func CORS(cfg config.Config) fiber.Handler {
+	return cors.New(cors.Config{
+		AllowOrigins:  strings.Join(cfg.CORSOrigins, ","),
+		AllowMethods:  "GET,HEAD,POST,PUT,PATCH,DELETE",
+		AllowHeaders:  "Content-Type,Accept,X-API-Key,If-Match,If-None-Match,X-Default-Limit",
+		ExposeHeaders: "ETag,Allow,Link,X-Total-Count,X-Request-ID,X-RateLimit-Limit,X-RateLimit-Remaining,X-RateLimit-Reset,Retry-After",
+	})
+}
+
+var compressLevels = map[string]compress.Level{
+	config.CompressOff:     compress.LevelDisabled,
+	config.CompressSpeed:   compress.LevelBestSpeed,
+	config.CompressDefault: compress.LevelDefault,
+	config.CompressBest:    compress.LevelBestCompression,
+}
+
+// Compress compresses responses with gzip, deflate or brotli, whichever the
+// client's Accept-Encoding prefers, at level, one of the config.Compress
+// levels. fasthttp leaves bodies under 200 bytes, bodies that already have
+// a Content-Encoding and types that do not compress alone, and compresses
+// streamed bodies such as the CSV export on the fly. Health checks are
+// never compressed. A compressed response's ETag is made weak, as its
+// bytes differ from the uncompressed ones. Use it outside CountRequests so
+// rendered errors are compressed too.
+func Compress(level string) fiber.Handler {
+	mw := compress.New(compress.Config{
+		Level: compressLevels[level],
+		Next: func(c *fiber.Ctx) bool {
+			return c.Path() == "/health" || c.Path() == "/readyz"
+		},
+	})
+	return func(c *fiber.Ctx) error {
+		if err := mw(c); err != nil {
+			return err
+		}
+		if len(c.Response().Header.Peek(fiber.HeaderContentEncoding)) > 0 {
+			if etag := c.GetRespHeader(fiber.HeaderETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+				c.Set(fiber.HeaderETag, "W/"+etag)
+			}
+		}
+		return nil
+	}
+}
+
+// RequestIDKey is the c.Locals key the request ID middleware stores each
+// request's ID under.
+const RequestIDKey = "requestid"
+
+// requestID returns the ID the request ID middleware gave the request, or
+// "" if it did not run.
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(RequestIDKey).(string)
+	return id
+}
+
+// RenderErrors renders the error a later handler returns with the app's
+// error handler. It is the one place errors are rendered: middleware that
+// reads the response, such as RequestLogger, Compress, CountRequests and
+// LogBodies, goes before it so it sees what the client gets, and anything
+// that can fail goes after it.
+func RenderErrors(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			_ = c.SendStatus(http.StatusInternalServerError)
+		}
+	}
+	return nil
+}
+
+// RequestLogger writes one JSON line to w for every request, with its ID,
+// method, path, status and latency. Use it before RenderErrors.
+func RequestLogger(w io.Writer) fiber.Handler {
+	logger := slog.New(slog.NewJSONHandler(w, nil))
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		logger.Info("request",
+			"request_id", requestID(c),
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", c.Response().StatusCode(),
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+		return err
+	}
+}
+
+// requireAPIKey lets write requests through only when their X-API-Key
+// header equals key. Reads stay public.
+func requireAPIKey(key string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		if !hasAPIKey(c, key) {
+			return newError(ErrUnauthorized, "missing or invalid API key")
+		}
+		return c.Next()
+	}
+}
+
+// hasAPIKey reports whether the X-API-Key header of c equals key.
+func hasAPIKey(c *fiber.Ctx, key string) bool {
+	// ConstantTimeCompare takes as long for a near miss as for a wild
+	// guess, so the key cannot be recovered by timing responses.
+	return subtle.ConstantTimeCompare([]byte(c.Get("X-API-Key")), []byte(key)) == 1
+}
+
+// redactedFields returns the fields to remove from the response to c: the
+// RESPONSE_REDACT_FIELDS, unless c carries the API key, nil if none.
+// Without API_KEY every client is redacted.
+func (h *Handler) redactedFields(c *fiber.Ctx) map[string]bool {
+	if len(h.responseRedact) == 0 || (h.cfg.APIKey != "" && hasAPIKey(c, h.cfg.APIKey)) {
+		return nil
+	}
+	return h.responseRedact
+}
+
+// LogBodies logs the request and response bodies of the requests under
+// prefix, the path the book routes are mounted at, when LOG_BODIES is set.
+// Bodies are truncated to LOG_BODIES_MAX_BYTES and the values of
+// LOG_REDACT_FIELDS are masked. It only reads the buffered bodies, so
+// handlers still see the full request. Use it before RenderErrors.
+func (h *Handler) LogBodies(prefix string) fiber.Handler {
+	if !h.cfg.LogBodies {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	log.Println("warning: LOG_BODIES is enabled, request and response bodies will be logged")
+	maxBytes := h.cfg.LogBodiesMaxBytes
+	redact := make(map[string]bool, len(h.cfg.LogRedactFields))
+	for _, f := range h.cfg.LogRedactFields {
+		redact[strings.ToLower(f)] = true
+	}
+	return func(c *fiber.Ctx) error {
+		if !strings.HasPrefix(c.Path(), prefix) {
+			return c.Next()
+		}
+		if body := c.Body(); len(body) > 0 {
+			log.Printf("%s %s request body: %s", c.Method(), c.OriginalURL(), formatBody(body, maxBytes, redact))
+		}
+		err := c.Next()
+		if body := c.Response().Body(); len(body) > 0 {
+			log.Printf("%s %s response body: %s", c.Method(), c.OriginalURL(), formatBody(body, maxBytes, redact))
+		}
+		return err
+	}
+}
+
+const redactedValue = "[REDACTED]"
+
+func formatBody(body []byte, maxBytes int, redact map[string]bool) string {
+	if len(redact) > 0 {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			if masked, err := json.Marshal(redactValue(v, redact)); err == nil {
+				body = masked
+			}
+		}
+	}
+	if len(body) > maxBytes {
+		return string(body[:maxBytes]) + "...(truncated)"
+	}
+	return string(body)
+}
+
+// redactValue walks a decoded JSON value and masks every object field named
+// in redact, matching names case-insensitively.
+func redactValue(v interface{}, redact map[string]bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, field := range t {
+			if redact[strings.ToLower(k)] {
+				t[k] = redactedValue
+			} else {
+				t[k] = redactValue(field, redact)
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = redactValue(item, redact)
+		}
+	}
+	return v
+}
+
+// allowQuery rejects requests carrying query parameters other than params
+// when strict query mode is enabled, so typos such as ?lmit=10 are reported
+// instead of silently ignored.
+func (h *Handler) allowQuery(params ...string) fiber.Handler {
+	if !h.cfg.StrictQuery {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	known := make(map[string]bool, len(params))
+	for _, p := range params {
+		known[p] = true
+	}
+	return func(c *fiber.Ctx) error {
+		var unknown string
+		c.Context().QueryArgs().VisitAll(func(key, _ []byte) {
+			if unknown == "" && !known[string(key)] {
+				unknown = string(key)
+			}
+		})
+		if unknown != "" {
+			return newError(ErrBadRequest, "unknown query parameter: "+unknown)
+		}
+		return c.Next()
+	}
+}
+
+// RejectDuringShutdown answers 503 and closes the connection for requests
+// that arrive once shuttingDown is set. Requests already past this point
+// run to completion.
+func RejectDuringShutdown(shuttingDown *atomic.Bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if shuttingDown.Load() {
+			c.Context().SetConnectionClose()
+			return fiber.NewError(http.StatusServiceUnavailable, "server is shutting down")
+		}
+		return c.Next()
+	}
+}
+
+// RateLimit limits each client IP to cfg.RateLimitMax requests per
+// cfg.RateLimitWindow and answers 429 beyond that, reporting the quota in
+// the X-RateLimit-* headers. Load balancers probe health and readiness and
+// Prometheus scrapes metrics far more often than clients call the API;
+// throttling them would take the instance out of rotation or leave gaps in
+// the graphs, so those paths are exempt. A RateLimitMax of zero turns
+// limiting off.
+func RateLimit(cfg config.Config) fiber.Handler {
+	if cfg.RateLimitMax == 0 {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	return limiter.New(limiter.Config{
+		Max:        cfg.RateLimitMax,
+		Expiration: cfg.RateLimitWindow,
+		Next: func(c *fiber.Ctx) bool {
+			switch c.Path() {
+			case "/health", "/readyz", "/metrics":
+				return true
+			}
+			return false
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return fiber.NewError(http.StatusTooManyRequests, "rate limit exceeded")
+		},
+	})
+}
+
+// RequireReady answers 503 until ready is set, so no request can read or
+// write the store before it has been loaded.
+func RequireReady(ready *atomic.Bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !ready.Load() {
+			return fiber.NewError(http.StatusServiceUnavailable, "server is starting")
+		}
+		return c.Next()
+	}
+}
+
+// handle registers handlers for method and path. A method disabled for this
+// deployment is registered to answer 405 instead, so clients can tell a
+// disabled operation from a missing resource. Like Router.Get, a GET route
+// also serves HEAD. Writes update the catalog size metric.
+func (h *Handler) handle(r fiber.Router, method, path string, handlers ...fiber.Handler) {
+	if method == fiber.MethodGet {
+		h.handle(r, fiber.MethodHead, path, handlers...)
+	}
+	if h.methodDisabled(method) {
+		r.Add(method, path, h.rejectDisabledMethod(path))
+		return
+	}
+	if _, ok := h.enabledMethods[path]; !ok {
+		h.paths = append(h.paths, path)
+	}
+	h.enabledMethods[path] = append(h.enabledMethods[path], method)
+	switch method {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		handlers = append([]fiber.Handler{h.countBooksAfter}, handlers...)
+	}
+	r.Add(method, path, handlers...)
+}
+
+// rejectOtherMethods answers 405 with an Allow header for methods no route
+// of a known path handles, instead of Fiber's 404. It must be called after
+// every route is registered. Static paths go first so that, e.g., /search
+// is not reported with the methods of :id.
+func (h *Handler) rejectOtherMethods(r fiber.Router) {
+	for _, static := range []bool{true, false} {
+		for _, path := range h.paths {
+			if strings.Contains(path, ":") == static {
+				continue
+			}
+			allow := strings.Join(h.enabledMethods[path], ", ")
+			r.All(path, func(c *fiber.Ctx) error {
+				c.Set(fiber.HeaderAllow, allow)
+				return newError(ErrMethodNotAllowed, "method not allowed")
+			})
+		}
+	}
+}
+
+func (h *Handler) methodDisabled(method string) bool {
+	for _, m := range h.cfg.DisabledMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) rejectDisabledMethod(path string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderAllow, strings.Join(h.enabledMethods[path], ", "))
+		return newError(ErrMethodNotAllowed, c.Method()+" is disabled on this server")
+	}
+}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"demo-golang/config"
+	"demo-golang/store"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/google/uuid"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	app := fiber.New()
+	app.Use(requestid.New(requestid.Config{Generator: uuid.NewString, ContextKey: RequestIDKey}))
+	app.Use(RequestLogger(&buf))
+	app.Use(RenderErrors)
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return fiber.NewError(http.StatusNotFound, "not here")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	id := resp.Header.Get(fiber.HeaderXRequestID)
+	if _, err := uuid.Parse(id); err != nil {
+		t.Errorf("X-Request-ID %q is not a UUID: %v", id, err)
+	}
+	var line struct {
+		RequestID string  `json:"request_id"`
+		Method    string  `json:"method"`
+		Path      string  `json:"path"`
+		Status    int     `json:"status"`
+		LatencyMS float64 `json:"latency_ms"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", buf.String(), err)
+	}
+	if line.RequestID != id || line.Method != http.MethodGet || line.Path != "/missing" || line.Status != http.StatusNotFound {
+		t.Errorf("log line = %+v, want request_id %s, GET /missing, status 404", line, id)
+	}
+}
+
+func TestUnsupportedMethodIsNotAllowed(t *testing.T) {
+	app, _ := newTestApp(t)
+
+	tests := []struct {
+		method, target, allow string
+	}{
+		{http.MethodPut, "/api/books/", "HEAD, GET, POST, PATCH, OPTIONS"},
+		{http.MethodPost, "/api/books/search", "HEAD, GET"},
+		{http.MethodPost, "/api/books/" + uuid.NewString(), "HEAD, GET, PATCH, PUT, DELETE, OPTIONS"},
+		{http.MethodGet, "/api/books/" + uuid.NewString() + "/restore", "POST"},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(tt.method, tt.target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, resp.StatusCode, http.StatusMethodNotAllowed)
+		}
+		if got := resp.Header.Get(fiber.HeaderAllow); got != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.target, got, tt.allow)
+		}
+		if err != nil || body.Error == "" {
+			t.Errorf("%s %s: body is not an error response: %v", tt.method, tt.target, err)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	s := store.New("", 1)
+	h := New(s, config.Default())
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+	app.Use(Compress(config.CompressDefault))
+	app.Get("/health", func(c *fiber.Ctx) error {
+		return c.SendString(strings.Repeat("ok ", 100))
+	})
+	h.Register(app.Group("/api").Group("/books"))
+	seed(t, s, 20)
+	all, _ := s.List()
+
+	get := func(target string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(fiber.HeaderAcceptEncoding, "gzip")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("/api/books/")
+	defer resp.Body.Close()
+	if got := resp.Header.Get(fiber.HeaderContentEncoding); got != "gzip" {
+		t.Fatalf("list: Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(zr).Decode(&page); err != nil || len(page.Data) != 20 {
+		t.Errorf("list: decoded %d books, err %v; want 20", len(page.Data), err)
+	}
+
+	resp = get("/api/books/" + all[0].ID)
+	resp.Body.Close()
+	if etag := resp.Header.Get(fiber.HeaderETag); resp.Header.Get(fiber.HeaderContentEncoding) == "gzip" && !strings.HasPrefix(etag, "W/") {
+		t.Errorf("compressed book has strong ETag %q", etag)
+	}
+
+	resp = get("/health")
+	resp.Body.Close()
+	if got := resp.Header.Get(fiber.HeaderContentEncoding); got != "" {
+		t.Errorf("health: Content-Encoding = %q, want none", got)
+	}
+}
+
+func TestRequireReady(t *testing.T) {
+	var ready atomic.Bool
+	s := store.New("", 1)
+	h := New(s, config.Default())
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+	h.Register(app.Group("/api", RequireReady(&ready)).Group("/books"))
+
+	status, _ := do(t, app, http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin"}`)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("before ready: status = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("before ready: store has %d books, want 0", n)
+	}
+
+	ready.Store(true)
+	if status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin"}`); status != http.StatusCreated {
+		t.Errorf("once ready: status = %d, want %d: %s", status, http.StatusCreated, body)
+	}
+}
+
+func TestRejectDuringShutdown(t *testing.T) {
+	var shuttingDown atomic.Bool
+	app := fiber.New()
+	app.Use(RejectDuringShutdown(&shuttingDown))
+	started, release := make(chan struct{}), make(chan struct{})
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		close(started)
+		<-release
+		return c.SendString("done")
+	})
+	app.Get("/fast", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	inFlight := make(chan *http.Response)
+	go func() {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/slow", nil), -1)
+		if err != nil {
+			t.Error(err)
+		}
+		inFlight <- resp
+	}()
+	<-started
+	shuttingDown.Store(true)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("new request: status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if !resp.Close {
+		t.Error("new request: connection is kept open, want Connection: close")
+	}
+
+	close(release)
+	if resp := <-inFlight; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("in-flight request did not complete with 200: %v", resp)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		policy, host, path string
+		status             int
+		location           string
+	}{
+		{config.HostPolicyReject, "api.example.com", "/api/ping", http.StatusOK, ""},
+		{config.HostPolicyReject, "API.example.com", "/api/ping", http.StatusOK, ""},
+		{config.HostPolicyReject, "evil.example.com", "/api/ping", http.StatusMisdirectedRequest, ""},
+		{config.HostPolicyRedirect, "www.example.com", "/api/ping?x=1", http.StatusMovedPermanently, "http://api.example.com/api/ping?x=1"},
+		{config.HostPolicyReject, "10.0.0.7", "/health", http.StatusOK, ""},
+		{config.HostPolicyReject, "api.example.com:8443", "/api/ping", http.StatusOK, ""},
+		{config.HostPolicyReject, "evil.example.com:8443", "/api/ping", http.StatusMisdirectedRequest, ""},
+		{config.HostPolicyRedirect, "www.example.com:8080", "/api/ping", http.StatusMovedPermanently, "http://api.example.com/api/ping"},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Use(CanonicalHost("api.example.com", tt.policy))
+		app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("ok") })
+		app.Get("/api/ping", func(c *fiber.Ctx) error { return c.SendString("pong") })
+
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = tt.host
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s%s: status = %d, want %d", tt.policy, tt.host, tt.path, resp.StatusCode, tt.status)
+		}
+		if got := resp.Header.Get(fiber.HeaderLocation); got != tt.location {
+			t.Errorf("%s %s%s: Location = %q, want %q", tt.policy, tt.host, tt.path, got, tt.location)
+		}
+	}
+}
+
+func TestLogBodies(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, enabled := range []bool{true, false} {
+		logged.Reset()
+		cfg := config.Default()
+		cfg.LogBodies = enabled
+		cfg.LogRedactFields = []string{"isbn"}
+		h := New(store.New("", 1), cfg)
+		app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+		app.Use(h.LogBodies("/api/books"))
+		app.Use(RenderErrors)
+		h.Register(app.Group("/api").Group("/books"))
+
+		status, body := do(t, app, http.MethodPost, "/api/books/", `{"title":"Clean Code","author":"Robert C. Martin","isbn":"9780132350884"}`)
+		if status != http.StatusCreated {
+			t.Fatalf("create: status = %d: %s", status, body)
+		}
+		do(t, app, http.MethodGet, "/api/books/"+uuid.NewString(), "")
+
+		out := logged.String()
+		for _, want := range []string{
+			`request body: {"author":"Robert C. Martin","isbn":"[REDACTED]","title":"Clean Code"}`,
+			`POST /api/books/ response body: {"author":"Robert C. Martin"`,
+			// Errors are logged as rendered for the client.
+			`response body: {"code":"not_found","error":"book not found"}`,
+		} {
+			if got := strings.Contains(out, want); got != enabled {
+				t.Errorf("LOG_BODIES=%v: logged %q = %v, want %v; log:\n%s", enabled, want, got, enabled, out)
+			}
+		}
+		if strings.Contains(out, "9780132350884") {
+			t.Errorf("LOG_BODIES=%v: redacted field was logged:\n%s", enabled, out)
+		}
+	}
+}
+
+// newRateLimitedApp serves /api/ping and the exempt paths behind RateLimit
+// with a limit of max requests a minute.
+func newRateLimitedApp(max int) *fiber.App {
+	cfg := config.Default()
+	cfg.RateLimitMax = max
+	h := New(store.New("", 1), cfg)
+	app := fiber.New(fiber.Config{ErrorHandler: h.ErrorHandler})
+	app.Use(RenderErrors)
+	app.Use(RateLimit(cfg))
+	for _, path := range []string{"/health", "/readyz", "/metrics", "/api/ping"} {
+		app.Get(path, func(c *fiber.Ctx) error { return c.SendString("ok") })
+	}
+	return app
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newRateLimitedApp(2)
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Error("over the limit: no Retry-After header")
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if want := (ErrorResponse{Error: "rate limit exceeded", Code: "too_many_requests"}); body != want {
+		t.Errorf("over the limit: body = %+v, want %+v", body, want)
+	}
+
+	for _, path := range []string{"/health", "/readyz", "/metrics"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s over the limit: status = %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	app := newRateLimitedApp(0)
+	for i := 0; i < 5; i++ {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, resp.StatusCode, http.StatusOK)
+		}
+		if got := resp.Header.Get("X-RateLimit-Limit"); got != "" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want none", i+1, got)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	app := newRateLimitedApp(3)
+	for i, want := range []string{"2", "1", "0"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want %q", i+1, got, "3")
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != want {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %q", i+1, got, want)
+		}
+		if resp.Header.Get("X-RateLimit-Reset") == "" {
+			t.Errorf("request %d: no X-RateLimit-Reset header", i+1)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	cfg := config.Default()
+	cfg.CORSOrigins = []string{"https://app.example.com"}
+	app := fiber.New()
+	app.Use(CORS(cfg))
+	app.Get("/api/ping", func(c *fiber.Ctx) error { return c.SendString("pong") })
+
+	tests := []struct {
+		origin, allowed string
+	}{
+		{"https://app.example.com", "https://app.example.com"},
+		{"https://evil.example.com", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/ping", nil)
+		req.Header.Set(fiber.HeaderOrigin, tt.origin)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin); got != tt.allowed {
+			t.Errorf("GET from %s: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.allowed)
+		}
+		// Without Access-Control-Allow-Origin the browser hides the whole
+		// response, so the exposed headers only matter when it is set.
+		if tt.allowed == "" {
+			continue
+		}
+		exposed := resp.Header.Get(fiber.HeaderAccessControlExposeHeaders)
+		for _, h := range []string{"ETag", "Link", "X-Total-Count", "X-Request-ID", "X-RateLimit-Remaining", "Retry-After"} {
+			if !strings.Contains(exposed, h) {
+				t.Errorf("GET from %s: Access-Control-Expose-Headers = %q, want %s in it", tt.origin, exposed, h)
+			}
+		}
+	}
+
+	// A preflight from an allowed origin may send the API key and If-Match.
+	req := httptest.NewRequest(http.MethodOptions, "/api/ping", nil)
+	req.Header.Set(fiber.HeaderOrigin, "https://app.example.com")
+	req.Header.Set(fiber.HeaderAccessControlRequestMethod, http.MethodPatch)
+	req.Header.Set(fiber.HeaderAccessControlRequestHeaders, "X-API-Key,If-Match")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight: status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if got := resp.Header.Get(fiber.HeaderAccessControlAllowHeaders); !strings.Contains(got, "X-API-Key") || !strings.Contains(got, "If-Match") {
+		t.Errorf("preflight: Access-Control-Allow-Headers = %q, want X-API-Key and If-Match", got)
+	}
+	if got := resp.Header.Get(fiber.HeaderAccessControlAllowMethods); !strings.Contains(got, http.MethodPatch) {
+		t.Errorf("preflight: Access-Control-Allow-Methods = %q, want PATCH", got)
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"demo-golang/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// mimeApplicationMsgpack is the media type of MessagePack request and
+// response bodies. JSON stays the default for both.
+const mimeApplicationMsgpack = "application/msgpack"
+
+const mimeApplicationYAML = "application/x-yaml"
+
+// mimeApplicationNDJSON is the media type of the NDJSON export, one JSON
+// value per line.
+const mimeApplicationNDJSON = "application/x-ndjson"
+
+// MarshalJSON keeps the warnings next to the book's fields; without it the
+// method promoted from the embedded Book would drop them.
+func (r BookWriteResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		models.BookView
+		Warnings []string `json:"warnings,omitempty"`
+	}{models.NewBookView(r.Book), r.Warnings})
+}
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error string `json:"error" example:"book not found"`
+	Code  string `json:"code" example:"not_found"`
+}
+
+// ValidationErrorResponse is the body of a 422 from creating or replacing
+// a book, listing every field that failed validation.
+type ValidationErrorResponse struct {
+	Error  string              `json:"error" example:"validation failed"`
+	Code   string              `json:"code" example:"validation_error"`
+	Errors []models.FieldError `json:"errors"`
+}
+
+type ResponseMeta struct {
+	RequestID string    `json:"requestId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// responseFormat is the media type the response body is sent in: whichever
+// of JSON, MessagePack and XML the Accept header prefers, JSON by default.
+func responseFormat(c *fiber.Ctx) string {
+	switch format := c.Accepts(fiber.MIMEApplicationJSON, mimeApplicationMsgpack, fiber.MIMEApplicationXML); format {
+	case mimeApplicationMsgpack, fiber.MIMEApplicationXML:
+		return format
+	}
+	return fiber.MIMEApplicationJSON
+}
+
+// sendJSON is the single place responses are serialized, so options that
+// shape every response body are applied here. Clients that prefer
+// MessagePack or XML in their Accept header get the same body in that
+// format.
+func (h *Handler) sendJSON(c *fiber.Ctx, status int, body interface{}) error {
+	root := xmlRoot(body)
+	if fields := h.redactedFields(c); fields != nil {
+		v, err := jsonValue(body)
+		if err != nil {
+			return err
+		}
+		body = stripFields(v, fields)
+	}
+	if h.cfg.ResponseMeta {
+		body = withMeta(c, body)
+	}
+	switch responseFormat(c) {
+	case mimeApplicationMsgpack:
+		raw, err := toMsgpack(body)
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, mimeApplicationMsgpack)
+		return c.Status(status).Send(raw)
+	case fiber.MIMEApplicationXML:
+		raw, err := toXML(root, body)
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+		return c.Status(status).Send(raw)
+	}
+	return c.Status(status).JSON(body)
+}
+
+// jsonValue returns the JSON form of body decoded generically, with numbers
+// kept as json.Number.
+func jsonValue(body interface{}) (interface{}, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// stripFields removes the object keys in fields, compared
+// case-insensitively, from a decoded JSON value at any depth.
+func stripFields(v interface{}, fields map[string]bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			if fields[strings.ToLower(k)] {
+				delete(t, k)
+			} else {
+				t[k] = stripFields(item, fields)
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = stripFields(item, fields)
+		}
+	}
+	return v
+}
+
+// toMsgpack encodes body by way of its JSON form, so MessagePack clients
+// see exactly the fields, names and computed values JSON clients do.
+func toMsgpack(body interface{}) ([]byte, error) {
+	v, err := jsonValue(body)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(msgpackValue(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlRoot names the root element of body in XML responses.
+func xmlRoot(body interface{}) string {
+	switch body.(type) {
+	case models.Book, BookWriteResponse:
+		return "book"
+	case ErrorResponse:
+		return "error"
+	}
+	return "response"
+}
+
+// toXML encodes body by way of its JSON form, like toMsgpack. Object keys
+// become elements, in sorted order, and array entries item elements.
+func toXML(root string, body interface{}) ([]byte, error) {
+	v, err := jsonValue(body)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := encodeXML(enc, root, v); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeXML(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := encodeXML(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXML(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// msgpackValue turns the numbers of a decoded JSON value into integers
+// where they fit, and floats otherwise. The YAML export uses it too.
+func msgpackValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = msgpackValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = msgpackValue(item)
+		}
+	}
+	return v
+}
+
+// parseBody decodes the request body into out. MessagePack bodies use the
+// JSON field names; anything else goes through Fiber's body parser.
+func parseBody(c *fiber.Ctx, out interface{}) error {
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(c.Get(fiber.HeaderContentType), ";")[0]))
+	if ct != mimeApplicationMsgpack {
+		return c.BodyParser(out)
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(c.Body()))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(out)
+}
+
+// parsePatch decodes a merge patch into out. The body is decoded generically
+// and passed through JSON, so patches sent as MessagePack keep null fields
+// apart from missing ones the same way JSON patches do.
+func parsePatch(c *fiber.Ctx, out interface{}) error {
+	var raw map[string]interface{}
+	if err := parseBody(c, &raw); err != nil {
+		return err
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// withMeta adds a meta object to body. Bodies that do not serialize to a
+// JSON object are returned unchanged.
+func withMeta(c *fiber.Ctx, body interface{}) interface{} {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return body
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+		return body
+	}
+	meta, err := json.Marshal(ResponseMeta{
+		RequestID: c.GetRespHeader(fiber.HeaderXRequestID),
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return body
+	}
+	obj["meta"] = meta
+	return obj
+}
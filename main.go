@@ -1,15 +1,24 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
-	"strconv"
-	"sync"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"demo-golang/config"
+	"demo-golang/handlers"
+	"demo-golang/models"
+	"demo-golang/store"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/google/uuid"
 
 	_ "demo-golang/docs"
@@ -30,251 +39,116 @@ import (
 
 // @BasePath /api
 
-type Book struct {
-	ID     string `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author"`
-	Year   int    `json:"year,omitempty"`
-}
-
-var (
-	storeMu sync.RWMutex
-	store   = map[string]Book{}
-)
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 
-func validateBookPayload(b *Book) error {
-	if b.Title == "" {
-		return errors.New("title is required")
-	}
-	if b.Author == "" {
-		return errors.New("author is required")
-	}
-	return nil
+// seedBooks are the sample books of -seed. Their IDs are fixed so demos and
+// scripts can refer to them across restarts.
+var seedBooks = []models.Book{
+	{ID: "00000000-0000-4000-8000-000000000001", Title: "Clean Architecture", Author: "Robert C. Martin", Year: 2017},
+	{ID: "00000000-0000-4000-8000-000000000002", Title: "The Go Programming Language", Author: "Alan A. A. Donovan", Year: 2015},
 }
 
-// getAllBooks godoc
-// @Summary Get all books
-// @Description Get list of books with optional pagination
-// @Tags books
-// @Produce json
-// @Param page query int false "Page number"
-// @Param limit query int false "Limit per page"
-// @Success 200 {object} map[string]interface{}
-// @Router /books/ [get]
-func getAllBooks(c *fiber.Ctx) error {
-	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("limit", "50"))
-	if page < 1 {
-		page = 1
+func seedData(s *store.Store) {
+	seeded, err := s.Seed(seedBooks)
+	if err != nil {
+		log.Println("seed:", err)
+		return
 	}
-	if limit < 1 {
-		limit = 50
+	if !seeded {
+		log.Println("seed: store is not empty, skipping")
 	}
-
-	storeMu.RLock()
-	defer storeMu.RUnlock()
-
-	books := make([]Book, 0, len(store))
-	for _, v := range store {
-		books = append(books, v)
-	}
-
-	start := (page - 1) * limit
-	if start > len(books) {
-		start = len(books)
-	}
-	end := start + limit
-	if end > len(books) {
-		end = len(books)
-	}
-	paged := books[start:end]
-
-	return c.Status(http.StatusOK).JSON(fiber.Map{
-		"data":  paged,
-		"page":  page,
-		"limit": limit,
-		"total": len(books),
-	})
-}
-
-// getBookByID godoc
-// @Summary Get a book by ID
-// @Tags books
-// @Produce json
-// @Param id path string true "Book ID"
-// @Success 200 {object} Book
-// @Failure 404 {object} map[string]string
-// @Router /books/{id} [get]
-func getBookByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	storeMu.RLock()
-	defer storeMu.RUnlock()
-	b, ok := store[id]
-	if !ok {
-		return fiber.NewError(http.StatusNotFound, "book not found")
-	}
-	return c.Status(http.StatusOK).JSON(b)
-}
-
-// createBook godoc
-// @Summary Create a new book
-// @Tags books
-// @Accept json
-// @Produce json
-// @Param book body Book true "Create book"
-// @Success 201 {object} Book
-// @Failure 400 {object} map[string]string
-// @Router /books/ [post]
-func createBook(c *fiber.Ctx) error {
-	var payload Book
-	if err := c.BodyParser(&payload); err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid JSON body")
-	}
-	if err := validateBookPayload(&payload); err != nil {
-		return fiber.NewError(http.StatusBadRequest, err.Error())
-	}
-	payload.ID = uuid.New().String()
-
-	storeMu.Lock()
-	store[payload.ID] = payload
-	storeMu.Unlock()
-
-	return c.Status(http.StatusCreated).JSON(payload)
 }
 
-// updateBook godoc
-// @Summary Partially update a book
-// @Tags books
-// @Accept json
-// @Produce json
-// @Param id path string true "Book ID"
-// @Param book body Book true "Update book"
-// @Success 200 {object} Book
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /books/{id} [patch]
-func updateBook(c *fiber.Ctx) error {
-	id := c.Params("id")
-	storeMu.RLock()
-	_, ok := store[id]
-	storeMu.RUnlock()
-	if !ok {
-		return fiber.NewError(http.StatusNotFound, "book not found")
-	}
+// shuttingDown is set once the server has been asked to stop.
+var shuttingDown atomic.Bool
 
-	var payload Book
-	if err := c.BodyParser(&payload); err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid JSON body")
-	}
+// ready is set once the store is loaded and the server can serve traffic.
+var ready atomic.Bool
 
-	storeMu.Lock()
-	existing := store[id]
-	if payload.Title != "" {
-		existing.Title = payload.Title
-	}
-	if payload.Author != "" {
-		existing.Author = payload.Author
-	}
-	if payload.Year != 0 {
-		existing.Year = payload.Year
-	}
-	store[id] = existing
-	storeMu.Unlock()
-
-	return c.Status(http.StatusOK).JSON(existing)
-}
-
-// replaceBook godoc
-// @Summary Replace a book (PUT)
-// @Tags books
-// @Accept json
-// @Produce json
-// @Param id path string true "Book ID"
-// @Param book body Book true "Replace book"
-// @Success 200 {object} Book
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /books/{id} [put]
-func replaceBook(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var payload Book
-	if err := c.BodyParser(&payload); err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid JSON body")
-	}
-	if err := validateBookPayload(&payload); err != nil {
-		return fiber.NewError(http.StatusBadRequest, err.Error())
-	}
-	payload.ID = id
-
-	storeMu.Lock()
-	if _, exists := store[id]; !exists {
-		storeMu.Unlock()
-		return fiber.NewError(http.StatusNotFound, "book not found")
-	}
-	store[id] = payload
-	storeMu.Unlock()
-
-	return c.Status(http.StatusOK).JSON(payload)
-}
-
-// deleteBook godoc
-// @Summary Delete a book by ID
-// @Tags books
-// @Produce json
-// @Param id path string true "Book ID"
-// @Success 204 "No Content"
-// @Failure 404 {object} map[string]string
-// @Router /books/{id} [delete]
-func deleteBook(c *fiber.Ctx) error {
-	id := c.Params("id")
-	storeMu.Lock()
-	defer storeMu.Unlock()
-	if _, ok := store[id]; !ok {
-		return fiber.NewError(http.StatusNotFound, "book not found")
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
-	delete(store, id)
-	return c.SendStatus(http.StatusNoContent)
-}
+	flag.BoolVar(&cfg.SeedData, "seed", cfg.SeedData, "fill an empty store with sample books (overrides SEED_DATA)")
+	flag.Parse()
 
-func seedData() {
-	b1 := Book{ID: uuid.New().String(), Title: "Clean Architecture", Author: "Robert C. Martin", Year: 2017}
-	b2 := Book{ID: uuid.New().String(), Title: "The Go Programming Language", Author: "Alan A. A. Donovan", Year: 2015}
-	storeMu.Lock()
-	store[b1.ID] = b1
-	store[b2.ID] = b2
-	storeMu.Unlock()
-}
+	books := store.New(cfg.BooksDBPath, cfg.SeqBase)
+	h := handlers.New(books, cfg)
 
-func main() {
-	app := fiber.New(fiber.Config{ErrorHandler: func(c *fiber.Ctx, err error) error {
-		if e, ok := err.(*fiber.Error); ok {
-			return c.Status(e.Code).JSON(fiber.Map{"error": e.Message})
-		}
-		log.Println("internal error:", err)
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
-	}})
+	app := fiber.New(fiber.Config{
+		BodyLimit:    cfg.BodyLimit,
+		ErrorHandler: h.ErrorHandler,
+	})
 
 	app.Use(recover.New())
-	app.Use(logger.New())
+	app.Use(requestid.New(requestid.Config{
+		Generator:  uuid.NewString,
+		ContextKey: handlers.RequestIDKey,
+	}))
+	app.Use(handlers.RequestLogger(os.Stdout))
+	app.Use(handlers.Compress(cfg.CompressLevel))
+	app.Use(h.CountRequests)
+	app.Use(h.LogBodies("/api/books"))
+	// Everything above reads the response, everything below can fail it.
+	app.Use(handlers.RenderErrors)
+	app.Use(handlers.CORS(cfg))
+	app.Use(handlers.RejectDuringShutdown(&shuttingDown))
+	app.Use(handlers.RateLimit(cfg))
+	if cfg.CanonicalHost != "" {
+		app.Use(handlers.CanonicalHost(cfg.CanonicalHost, cfg.CanonicalHostPolicy))
+	}
 
 	// Swagger docs
 	app.Get("/swagger/*", fiberSwagger.New())
 
-	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	// Get also registers the route for HEAD, which some load balancers
+	// probe with; fasthttp drops the body for HEAD responses.
+	app.Get("/health", handlers.Health)
+	app.Get("/readyz", handlers.Readiness(&ready))
 
-	r := app.Group("/api")
-	books := r.Group("/books")
-	books.Get("/", getAllBooks)
-	books.Get(":id", getBookByID)
-	books.Post("/", createBook)
-	books.Patch(":id", updateBook)
-	books.Put(":id", replaceBook)
-	books.Delete(":id", deleteBook)
+	app.Get("/metrics", h.Metrics())
 
-	seedData()
+	// The server listens while the store loads, so /health and /readyz
+	// answer; the API itself waits for the load to finish, or a write could
+	// be persisted over the catalog on disk.
+	r := app.Group("/api", handlers.RequireReady(&ready))
+	h.Register(r.Group("/books"))
 
-	log.Println("listening on http://localhost:3000")
-	if err := app.Listen(":3000"); err != nil {
+	go func() {
+		log.Printf("listening on http://localhost:%d", cfg.Port)
+		if err := app.Listen(fmt.Sprintf(":%d", cfg.Port)); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	if _, err := books.Load(cfg.InvalidRecords); err != nil {
 		log.Fatal(err)
 	}
+	if cfg.SeedData {
+		seedData(books)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	if cfg.SoftDeleteRetention > 0 {
+		go books.PurgeTrashEvery(ctx, cfg.SoftDeleteRetention, cfg.SoftDeletePurgeInterval)
+	}
+	ready.Store(true)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	// Refuse new requests first so load balancers stop routing here, then
+	// give in-flight requests time to finish.
+	ready.Store(false)
+	shuttingDown.Store(true)
+	stop()
+	log.Printf("shutting down, refusing new requests for %s", cfg.ShutdownDrainDelay)
+	time.Sleep(cfg.ShutdownDrainDelay)
+	if err := app.ShutdownWithTimeout(cfg.ShutdownTimeout); err != nil {
+		log.Println("shutdown:", err)
+	}
 }
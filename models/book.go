@@ -0,0 +1,146 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Book struct {
+	ID             string    `json:"id"`
+	Title          string    `json:"title"`
+	Author         string    `json:"author"`
+	Year           int       `json:"year,omitempty"`
+	Copies         int       `json:"copies"`
+	PublishedCity  string    `json:"published_city,omitempty"`
+	Latitude       *float64  `json:"latitude,omitempty"`
+	Longitude      *float64  `json:"longitude,omitempty"`
+	GeneratedTitle bool      `json:"generatedTitle,omitempty"`
+	Language       string    `json:"language,omitempty" example:"en"`
+	ISBN           string    `json:"isbn,omitempty" example:"9780134190440"`
+	Tags           []string  `json:"tags,omitempty" example:"scifi,classic"`
+	Seq            int64     `json:"seq"`
+	Version        int64     `json:"version"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	// DeletedAt is set while the book is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Views counts how often the book was fetched by ID. The store fills
+	// it in when the book is read and never saves it.
+	Views int64 `json:"views,omitempty"`
+}
+
+// Citation formats the book for display as "Author, Title (Year)", leaving
+// out the year when it is unset.
+func (b Book) Citation() string {
+	if b.Year == 0 {
+		return fmt.Sprintf("%s, %s", b.Author, b.Title)
+	}
+	return fmt.Sprintf("%s, %s (%d)", b.Author, b.Title, b.Year)
+}
+
+// BookFields has the fields of Book without its methods, so it serializes
+// with the default encoding.
+type BookFields Book
+
+// BookView is the serialized form of a Book: its stored fields plus the
+// read-only fields computed from them.
+type BookView struct {
+	BookFields
+	Citation string `json:"citation"`
+}
+
+func NewBookView(b Book) BookView {
+	return BookView{BookFields: BookFields(b), Citation: b.Citation()}
+}
+
+// MarshalJSON adds the computed fields to every serialized book. Book has
+// no citation field, so a citation sent by a client is never stored.
+func (b Book) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewBookView(b))
+}
+
+// ErrUnknownLanguage reports a language that is not an ISO 639-1 code.
+var ErrUnknownLanguage = errors.New("language must be an ISO 639-1 code")
+
+// minYear is the earliest publication year a book may have. Zero, meaning
+// the year is unknown, is allowed too.
+const minYear = 1000
+
+// ValidateBookPayload checks b and normalizes its language code and ISBN in
+// place. It reports every problem it finds in a *ValidationError.
+func ValidateBookPayload(b *Book) error {
+	var verr ValidationError
+	if strings.TrimSpace(b.Title) == "" {
+		verr.addf("title", "title is required")
+	}
+	if strings.TrimSpace(b.Author) == "" {
+		verr.addf("author", "author is required")
+	}
+	if b.Year != 0 {
+		// Next year is allowed so upcoming releases can be catalogued.
+		if maxYear := time.Now().Year() + 1; b.Year < minYear || b.Year > maxYear {
+			verr.add("year", fmt.Errorf("year must be between %d and %d, got %d", minYear, maxYear, b.Year))
+		}
+	}
+	if b.Copies < 0 {
+		verr.addf("copies", "copies must not be negative")
+	}
+	switch {
+	case b.Latitude != nil && b.Longitude == nil:
+		verr.addf("longitude", "latitude and longitude must be given together")
+	case b.Latitude == nil && b.Longitude != nil:
+		verr.addf("latitude", "latitude and longitude must be given together")
+	}
+	if b.Latitude != nil && (*b.Latitude < -90 || *b.Latitude > 90) {
+		verr.addf("latitude", "latitude must be between -90 and 90")
+	}
+	if b.Longitude != nil && (*b.Longitude < -180 || *b.Longitude > 180) {
+		verr.addf("longitude", "longitude must be between -180 and 180")
+	}
+	if b.Language != "" {
+		b.Language = strings.ToLower(strings.TrimSpace(b.Language))
+		if !iso639Codes[b.Language] {
+			verr.add("language", fmt.Errorf("%w, got %q", ErrUnknownLanguage, b.Language))
+		}
+	}
+	if b.ISBN != "" {
+		if isbn, err := normalizeISBN(b.ISBN); err != nil {
+			verr.add("isbn", err)
+		} else {
+			b.ISBN = isbn
+		}
+	}
+	if b.Tags != nil {
+		if tags, err := normalizeTags(b.Tags); err != nil {
+			verr.add("tags", err)
+		} else {
+			b.Tags = tags
+		}
+	}
+	return verr.err()
+}
+
+// normalizeTags trims each tag and drops later duplicates, compared
+// case-insensitively. It returns a new slice, since the given one may be
+// shared with a stored book.
+func normalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, errors.New("tags must not be blank")
+		}
+		if key := strings.ToLower(tag); !seen[key] {
+			seen[key] = true
+			out = append(out, tag)
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// normalizeISBN strips the hyphens and spaces from an ISBN-10 or ISBN-13
+// and checks its length, characters and check digit.
+func normalizeISBN(s string) (string, error) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	switch len(isbn) {
+	case 10:
+		return isbn, checkISBN10(isbn)
+	case 13:
+		return isbn, checkISBN13(isbn)
+	default:
+		return "", fmt.Errorf("isbn must have 10 or 13 digits, got %q", s)
+	}
+}
+
+// checkISBN10 verifies the weighted sum of the digits is a multiple of 11.
+// The check digit may be X, standing for 10.
+func checkISBN10(isbn string) error {
+	sum := 0
+	for i, r := range isbn {
+		var d int
+		switch {
+		case r >= '0' && r <= '9':
+			d = int(r - '0')
+		case r == 'X' && i == 9:
+			d = 10
+		default:
+			return fmt.Errorf("isbn %q contains an invalid character %q", isbn, r)
+		}
+		sum += (10 - i) * d
+	}
+	if sum%11 != 0 {
+		return fmt.Errorf("isbn %q has an invalid ISBN-10 check digit", isbn)
+	}
+	return nil
+}
+
+// checkISBN13 verifies the digits, weighted alternately 1 and 3, add up to
+// a multiple of 10.
+func checkISBN13(isbn string) error {
+	sum := 0
+	for i, r := range isbn {
+		if r < '0' || r > '9' {
+			return fmt.Errorf("isbn %q contains an invalid character %q", isbn, r)
+		}
+		d := int(r - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	if sum%10 != 0 {
+		return fmt.Errorf("isbn %q has an invalid ISBN-13 check digit", isbn)
+	}
+	return nil
+}
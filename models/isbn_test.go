@@ -0,0 +1,40 @@
+package models
+
+import "testing"
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		// ISBN-10
+		{"0306406152", "0306406152", true},
+		{"0-306-40615-2", "0306406152", true},
+		{"0 201 48567 2", "0201485672", true},
+		{"080442957X", "080442957X", true},
+		{"0-8044-2957-x", "080442957X", true},
+		{"0306406153", "", false},
+		{"0804429579", "", false},
+		{"X306406152", "", false},
+		{"03064O6152", "", false},
+		// ISBN-13
+		{"9780306406157", "9780306406157", true},
+		{"978-0-13-475759-9", "9780134757599", true},
+		{"979 0000000001", "9790000000001", true},
+		{"9780306406158", "", false},
+		{"978030640615X", "", false},
+		// Neither length
+		{"", "", false},
+		{"030640615", "", false},
+		{"97803064061570", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeISBN(tt.in)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("normalizeISBN(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("normalizeISBN(%q) = %q, want an error", tt.in, got)
+		}
+	}
+}
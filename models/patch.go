@@ -0,0 +1,46 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Optional is a field of a merge patch. It tells a field that was left out
+// of the patch apart from one sent as null and one sent with a value.
+type Optional[T any] struct {
+	// Set reports whether the field was present in the patch.
+	Set bool
+	// Null reports whether the field was sent as null.
+	Null  bool
+	Value T
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if bytes.Equal(data, []byte("null")) {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// BookPatch is a JSON merge patch (RFC 7396) of a Book: fields left out are
+// unchanged and fields sent as null are cleared.
+type BookPatch struct {
+	Title         Optional[string]   `json:"title"`
+	Author        Optional[string]   `json:"author"`
+	Year          Optional[int]      `json:"year"`
+	Copies        Optional[int]      `json:"copies"`
+	PublishedCity Optional[string]   `json:"published_city"`
+	Latitude      Optional[float64]  `json:"latitude"`
+	Longitude     Optional[float64]  `json:"longitude"`
+	Language      Optional[string]   `json:"language"`
+	ISBN          Optional[string]   `json:"isbn"`
+	Tags          Optional[[]string] `json:"tags"`
+}
+
+// IsEmpty reports whether the patch changes no field.
+func (p BookPatch) IsEmpty() bool {
+	return !p.Title.Set && !p.Author.Set && !p.Year.Set && !p.Copies.Set && !p.PublishedCity.Set &&
+		!p.Latitude.Set && !p.Longitude.Set && !p.Language.Set && !p.ISBN.Set && !p.Tags.Set
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// FieldError is a problem with one field of a book.
+type FieldError struct {
+	Field   string `json:"field" example:"title"`
+	Message string `json:"message" example:"title is required"`
+	err     error
+}
+
+// ValidationError lists every problem ValidateBookPayload found in a book,
+// so a client can fix them all in one go.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the error of each field, so errors.Is can look for one,
+// e.g. ErrUnknownLanguage.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Fields))
+	for i, f := range e.Fields {
+		errs[i] = f.err
+	}
+	return errs
+}
+
+// add records err as a problem with field.
+func (e *ValidationError) add(field string, err error) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: err.Error(), err: err})
+}
+
+// addf records a problem with field described by msg.
+func (e *ValidationError) addf(field, msg string) {
+	e.add(field, errors.New(msg))
+}
+
+// err returns e, or nil if no problem was recorded.
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"demo-golang/config"
+	"demo-golang/models"
+)
+
+// storeFile is the on-disk form of the store.
+type storeFile struct {
+	Version       int64               `json:"version"`
+	PurgedVersion int64               `json:"purged_version,omitempty"`
+	LastSeq       int64               `json:"last_seq"`
+	Books         []models.BookFields `json:"books"`
+	Quarantined   []models.BookFields `json:"quarantined,omitempty"`
+	Trash         []models.BookFields `json:"trash,omitempty"`
+}
+
+// Load fills the store from its file. It reports false when persistence is
+// off or the file does not exist yet. Books that no longer pass validation,
+// e.g. after a rule was tightened, are logged and handled according to
+// onInvalid, one of the config.InvalidRecords policies.
+func (s *Store) Load(onInvalid string) (bool, error) {
+	path := s.path
+	if path == "" {
+		return false, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var f storeFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return false, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range f.Books {
+		book := models.Book(b)
+		check := book
+		if err := models.ValidateBookPayload(&check); err != nil {
+			log.Printf("%s: book %s is invalid: %v", path, book.ID, err)
+			switch onInvalid {
+			case config.InvalidRecordsFail:
+				clear(s.books)
+				s.quarantined = nil
+				return false, fmt.Errorf("%s: book %s is invalid: %w", path, book.ID, err)
+			case config.InvalidRecordsQuarantine:
+				s.quarantined = append(s.quarantined, book)
+				continue
+			}
+		}
+		s.books[book.ID] = book
+	}
+	for _, b := range f.Quarantined {
+		s.quarantined = append(s.quarantined, models.Book(b))
+	}
+	for _, b := range f.Trash {
+		s.trash[b.ID] = models.Book(b)
+	}
+	if len(s.quarantined) > 0 {
+		log.Printf("%s: %d book(s) quarantined", path, len(s.quarantined))
+	}
+	s.version = f.Version
+	s.purgedVersion = f.PurgedVersion
+	s.lastSeq = f.LastSeq
+	return true, nil
+}
+
+// persistLocked writes the store to its file, if any. The file is replaced
+// atomically so a crash mid-write never leaves it truncated. s.mu must be
+// held so the snapshot is consistent.
+func (s *Store) persistLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	f := storeFile{Version: s.version, PurgedVersion: s.purgedVersion, LastSeq: s.lastSeq, Books: make([]models.BookFields, 0, len(s.books))}
+	for _, b := range s.books {
+		f.Books = append(f.Books, models.BookFields(b))
+	}
+	for _, b := range s.quarantined {
+		f.Quarantined = append(f.Quarantined, models.BookFields(b))
+	}
+	for _, b := range s.trash {
+		f.Trash = append(f.Trash, models.BookFields(b))
+	}
+	raw, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".books-*.json")
+	if err != nil {
+		return fmt.Errorf("persist store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("persist store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("persist store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("persist store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("persist store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"demo-golang/config"
+	"demo-golang/models"
+)
+
+// writeStoreFile writes a store file holding a valid book and one with a
+// year no longer allowed, and returns its path.
+func writeStoreFile(t *testing.T) string {
+	t.Helper()
+	f := storeFile{Version: 2, LastSeq: 2, Books: []models.BookFields{
+		{ID: "valid", Title: "Clean Code", Author: "Robert C. Martin", Year: 2008, Seq: 1},
+		{ID: "invalid", Title: "Ancient", Author: "Unknown", Year: 500, Seq: 2},
+	}}
+	raw, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "books.json")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadQuarantinesInvalidBooks(t *testing.T) {
+	path := writeStoreFile(t)
+	s := New(path, 1)
+	if loaded, err := s.Load(config.InvalidRecordsQuarantine); err != nil || !loaded {
+		t.Fatalf("Load() = %v, %v; want true, nil", loaded, err)
+	}
+	if _, ok := s.Get("invalid"); ok {
+		t.Error("invalid book is served, want it quarantined")
+	}
+	if _, ok := s.Get("valid"); !ok {
+		t.Error("valid book was not loaded")
+	}
+
+	// Quarantined books are saved back so they can be repaired by hand.
+	if _, err := s.Create(models.Book{Title: "Refactoring", Author: "Martin Fowler"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f storeFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Quarantined) != 1 || f.Quarantined[0].ID != "invalid" || len(f.Books) != 2 {
+		t.Errorf("saved %d books and quarantined %+v, want 2 books and the invalid one quarantined", len(f.Books), f.Quarantined)
+	}
+}
+
+func TestLoadFailsOnInvalidBooks(t *testing.T) {
+	s := New(writeStoreFile(t), 1)
+	if _, err := s.Load(config.InvalidRecordsFail); err == nil {
+		t.Fatal("Load() succeeded, want an error for the invalid book")
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("store holds %d books after a failed load, want 0", n)
+	}
+}
+
+func TestLoadKeepsInvalidBooks(t *testing.T) {
+	s := New(writeStoreFile(t), 1)
+	if _, err := s.Load(config.InvalidRecordsKeep); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Len(); n != 2 {
+		t.Errorf("store holds %d books, want both", n)
+	}
+}
@@ -0,0 +1,478 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"demo-golang/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned for operations on a book that does not exist.
+var ErrNotFound = errors.New("book not found")
+
+// Kinds of Change.
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// Change is a write to one book, as reported to the functions registered
+// with Watch.
+type Change struct {
+	// Kind is ChangeCreated, ChangeUpdated or ChangeDeleted. Restoring a
+	// book from the trash creates it again.
+	Kind string
+	// Book is the book as written, or as it was when deleted.
+	Book models.Book
+}
+
+// Tx is the store as seen inside Store.Tx. Every method runs under the
+// write lock, so checks made through it still hold when the write happens.
+type Tx interface {
+	Get(id string) (models.Book, bool)
+	List() []models.Book
+	// Create stores b as a new book with a fresh ID and catalog number.
+	Create(b models.Book) models.Book
+	// Save stores b over the existing book with its ID, keeping the
+	// catalog number and creation time of the stored copy. A saved book is
+	// never in the trash.
+	Save(b models.Book) models.Book
+	// Delete moves the book with the given ID to the trash.
+	Delete(id string) bool
+	// Trashed looks up a book in the trash.
+	Trashed(id string) (models.Book, bool)
+	// Restore moves the book with the given ID out of the trash.
+	Restore(id string) (models.Book, bool)
+	// Purge removes the book with the given ID for good, whether or not it
+	// is in the trash.
+	Purge(id string) bool
+}
+
+// Store keeps the books in memory and, when it has a path, mirrors every
+// change to a JSON file.
+type Store struct {
+	mu    sync.RWMutex
+	books map[string]models.Book
+	// trash holds deleted books until they are restored or purged. They
+	// are hidden from every lookup but Trashed and Trash.
+	trash map[string]models.Book
+	// lastSeq is the catalog number most recently assigned.
+	lastSeq int64
+	// version counts the mutations made to the store.
+	version int64
+	// purgedVersion is the store version of the latest purge. Purged books
+	// leave no trace, so the changes made before it cannot be told apart.
+	purgedVersion int64
+	seqBase       int64
+	path          string
+	// quarantined holds loaded books that failed validation under the
+	// quarantine policy. They are not served but are saved back with the
+	// store so they can be repaired by hand.
+	quarantined []models.Book
+	// onChange is called with the ID of every book written or removed,
+	// under the write lock.
+	onChange []func(id string)
+	// watchers are called with the changes of every write, after the write
+	// lock is released. notifyMu keeps them seeing writes in order.
+	watchers []func(changes []Change)
+	notifyMu sync.Mutex
+	// views holds a *atomic.Int64 view counter per book ID. It is kept
+	// apart from books so counting a view needs only the read lock.
+	views sync.Map
+}
+
+// New returns an empty store whose first book gets catalog number seqBase.
+// An empty path keeps the store in memory only.
+func New(path string, seqBase int64) *Store {
+	return &Store{books: map[string]models.Book{}, trash: map[string]models.Book{}, path: path, seqBase: seqBase}
+}
+
+func (s *Store) Get(id string) (models.Book, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getLocked(id)
+}
+
+// AddView counts a view of the book with the given ID and returns its new
+// view count, or 0 if there is no such book. Holding the read lock keeps a
+// concurrent delete from leaving a counter behind.
+func (s *Store) AddView(id string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.books[id]
+	if !ok {
+		return 0
+	}
+	// Key by the stored ID: id may alias a request buffer that is reused.
+	n, _ := s.views.LoadOrStore(b.ID, new(atomic.Int64))
+	return n.(*atomic.Int64).Add(1)
+}
+
+// List returns a snapshot of every book, in no particular order, together
+// with the store version it was taken at.
+func (s *Store) List() ([]models.Book, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listLocked(), s.version
+}
+
+// ChangesSince returns the books written and the IDs of the books deleted
+// after store version since, together with the current version. Deleted
+// books are told from the trash. A purge after since leaves no trace of the
+// book it removed, so then every book is returned instead, with false, and
+// the caller has to start over from them.
+func (s *Store) ChangesSince(since int64) (changed []models.Book, deleted []string, version int64, complete bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if since < s.purgedVersion {
+		return s.listLocked(), []string{}, s.version, false
+	}
+	changed = make([]models.Book, 0)
+	for _, b := range s.listLocked() {
+		if b.Version > since {
+			changed = append(changed, b)
+		}
+	}
+	deleted = make([]string, 0)
+	for _, b := range s.trash {
+		if b.Version > since {
+			deleted = append(deleted, b.ID)
+		}
+	}
+	sort.Strings(deleted)
+	return changed, deleted, s.version, true
+}
+
+// Len returns the number of books without copying them.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.books)
+}
+
+func (s *Store) Create(b models.Book) (models.Book, error) {
+	var created models.Book
+	err := s.Tx(func(tx Tx) error {
+		created = tx.Create(b)
+		return nil
+	})
+	return created, err
+}
+
+// Seed stores books under the IDs they carry, but only if the store holds
+// no books at all, trashed or quarantined ones included, so a catalog that
+// was emptied on purpose is not refilled. It reports whether it did.
+func (s *Store) Seed(books []models.Book) (bool, error) {
+	seeded := false
+	err := s.Tx(func(tx Tx) error {
+		if len(s.books) > 0 || len(s.trash) > 0 || len(s.quarantined) > 0 {
+			return nil
+		}
+		for _, b := range books {
+			b.Seq = s.nextSeqLocked()
+			b.CreatedAt = time.Time{}
+			tx.Save(b)
+		}
+		seeded = true
+		return nil
+	})
+	return seeded, err
+}
+
+// Update applies fn to a copy of the book with the given ID and stores the
+// result, unless fn fails.
+func (s *Store) Update(id string, fn func(b *models.Book) error) (models.Book, error) {
+	var updated models.Book
+	err := s.Tx(func(tx Tx) error {
+		b, ok := tx.Get(id)
+		if !ok {
+			return ErrNotFound
+		}
+		if err := fn(&b); err != nil {
+			return err
+		}
+		updated = tx.Save(b)
+		return nil
+	})
+	return updated, err
+}
+
+// Delete moves the book with the given ID to the trash, from where it can
+// be restored.
+func (s *Store) Delete(id string) error {
+	return s.Tx(func(tx Tx) error {
+		if !tx.Delete(id) {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// Purge removes the book with the given ID for good, whether or not it is
+// in the trash.
+func (s *Store) Purge(id string) error {
+	return s.Tx(func(tx Tx) error {
+		if !tx.Purge(id) {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// OnChange registers fn to be called with the ID of every book that is
+// saved, deleted, restored or purged. It runs under the write lock, before
+// any reader can see the change, so it must be quick and must not use the
+// store.
+func (s *Store) OnChange(fn func(id string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// Watch registers fn to be called with the changes of every write that
+// changed books, in the order the writes happened. It runs after the write
+// lock is released, so it may use the store, but it delays the next
+// write's watchers until it returns.
+func (s *Store) Watch(fn func(changes []Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers = append(s.watchers, fn)
+}
+
+// PurgeTrash purges the books that were deleted longer than retention ago
+// and returns how many it removed.
+func (s *Store) PurgeTrash(retention time.Duration) (int, error) {
+	cutoff := time.Now().Add(-retention)
+	purged := 0
+	err := s.Tx(func(tx Tx) error {
+		for id, b := range s.trash {
+			if b.DeletedAt != nil && b.DeletedAt.Before(cutoff) && tx.Purge(id) {
+				purged++
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// PurgeTrashEvery runs PurgeTrash every interval until ctx is done, logging
+// how many books each run removed.
+func (s *Store) PurgeTrashEvery(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.PurgeTrash(retention)
+			if err != nil {
+				log.Println("purge trash:", err)
+				continue
+			}
+			log.Printf("purge trash: %d book(s) deleted more than %s ago purged", n, retention)
+		}
+	}
+}
+
+// Trash returns a snapshot of the deleted books, in no particular order.
+func (s *Store) Trash() []models.Book {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	books := make([]models.Book, 0, len(s.trash))
+	for _, b := range s.trash {
+		books = append(books, b)
+	}
+	return books
+}
+
+// Tx runs fn with the write lock held and saves the store afterwards if fn
+// changed it. Changes made before fn fails are kept, so fn should check
+// everything it can before writing.
+//
+// The changes fn made are passed to the watchers once the lock is released,
+// so slow watchers hold up later watchers but never writers.
+func (s *Store) Tx(fn func(tx Tx) error) error {
+	t := &tx{s: s}
+	var err error
+	func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		err = fn(t)
+		if t.dirty {
+			if perr := s.persistLocked(); perr != nil && err == nil {
+				err = perr
+			}
+		}
+		// Taken before the write lock is released, so the next write
+		// cannot notify first.
+		s.notifyMu.Lock()
+	}()
+	defer s.notifyMu.Unlock()
+	if len(t.changes) > 0 {
+		for _, fn := range s.watchers {
+			fn(t.changes)
+		}
+	}
+	return err
+}
+
+func (s *Store) changedLocked(id string) {
+	for _, fn := range s.onChange {
+		fn(id)
+	}
+}
+
+func (s *Store) getLocked(id string) (models.Book, bool) {
+	b, ok := s.books[id]
+	if ok {
+		b.Views = s.viewsOf(id)
+	}
+	return b, ok
+}
+
+func (s *Store) listLocked() []models.Book {
+	books := make([]models.Book, 0, len(s.books))
+	for _, b := range s.books {
+		b.Views = s.viewsOf(b.ID)
+		books = append(books, b)
+	}
+	return books
+}
+
+func (s *Store) viewsOf(id string) int64 {
+	if n, ok := s.views.Load(id); ok {
+		return n.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+type tx struct {
+	s       *Store
+	dirty   bool
+	changes []Change
+}
+
+func (t *tx) Get(id string) (models.Book, bool) {
+	return t.s.getLocked(id)
+}
+
+func (t *tx) List() []models.Book {
+	return t.s.listLocked()
+}
+
+func (t *tx) Create(b models.Book) models.Book {
+	b.ID = t.s.newIDLocked()
+	b.Seq = t.s.nextSeqLocked()
+	b.CreatedAt = time.Time{}
+	return t.Save(b)
+}
+
+// Save stamps b with the store version and time of this write.
+func (t *tx) Save(b models.Book) models.Book {
+	t.dirty = true
+	s := t.s
+	s.version++
+	b.Version = s.version
+	now := time.Now().UTC()
+	kind := ChangeCreated
+	if existing, ok := s.books[b.ID]; ok {
+		kind = ChangeUpdated
+		b.Seq = existing.Seq
+		b.CreatedAt = existing.CreatedAt
+	} else if b.CreatedAt.IsZero() {
+		b.CreatedAt = now
+	}
+	b.UpdatedAt = now
+	b.DeletedAt = nil
+	b.Views = 0
+	s.books[b.ID] = b
+	s.changedLocked(b.ID)
+	t.changes = append(t.changes, Change{Kind: kind, Book: b})
+	return b
+}
+
+func (t *tx) Delete(id string) bool {
+	b, ok := t.s.books[id]
+	if !ok {
+		return false
+	}
+	t.dirty = true
+	t.s.version++
+	// Stamp the deletion so ChangesSince can tell when it happened.
+	b.Version = t.s.version
+	now := time.Now().UTC()
+	b.DeletedAt = &now
+	delete(t.s.books, id)
+	t.s.views.Delete(id)
+	// Key by the stored ID: id may alias a request buffer that is reused.
+	t.s.trash[b.ID] = b
+	t.s.changedLocked(b.ID)
+	t.changes = append(t.changes, Change{Kind: ChangeDeleted, Book: b})
+	return true
+}
+
+func (t *tx) Trashed(id string) (models.Book, bool) {
+	b, ok := t.s.trash[id]
+	return b, ok
+}
+
+func (t *tx) Restore(id string) (models.Book, bool) {
+	b, ok := t.s.trash[id]
+	if !ok {
+		return models.Book{}, false
+	}
+	delete(t.s.trash, id)
+	return t.Save(b), true
+}
+
+func (t *tx) Purge(id string) bool {
+	b, live := t.s.books[id]
+	_, trashed := t.s.trash[id]
+	if !live && !trashed {
+		return false
+	}
+	t.dirty = true
+	t.s.version++
+	t.s.purgedVersion = t.s.version
+	delete(t.s.books, id)
+	delete(t.s.trash, id)
+	t.s.views.Delete(id)
+	t.s.changedLocked(id)
+	// A book purged from the trash was already reported deleted.
+	if live {
+		t.changes = append(t.changes, Change{Kind: ChangeDeleted, Book: b})
+	}
+	return true
+}
+
+// nextSeqLocked assigns the next catalog number. Taking it under the same
+// write lock that stores the book keeps the sequence unique and gap-free.
+func (s *Store) nextSeqLocked() int64 {
+	if s.lastSeq < s.seqBase {
+		s.lastSeq = s.seqBase - 1
+	}
+	s.lastSeq++
+	return s.lastSeq
+}
+
+// newIDLocked returns a fresh ID that no stored book uses, so a create can
+// never overwrite an existing book.
+func (s *Store) newIDLocked() string {
+	for {
+		id := uuid.New().String()
+		_, live := s.books[id]
+		_, trashed := s.trash[id]
+		if !live && !trashed {
+			return id
+		}
+	}
+}
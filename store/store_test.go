@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"demo-golang/config"
+	"demo-golang/models"
+)
+
+func TestSeqIsUniqueAndGapFreeUnderConcurrentCreates(t *testing.T) {
+	const base, n = 1000, 100
+	s := New("", base)
+
+	var wg sync.WaitGroup
+	created := make([]models.Book, n)
+	for i := range created {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := s.Create(models.Book{Title: "Book", Author: "Author"})
+			if err != nil {
+				t.Error(err)
+			}
+			created[i] = b
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(created, func(i, j int) bool { return created[i].Seq < created[j].Seq })
+	for i, b := range created {
+		if want := int64(base + i); b.Seq != want {
+			t.Fatalf("catalog numbers are not %d..%d without gaps or repeats: position %d has %d", base, base+n-1, i, b.Seq)
+		}
+		// Catalog numbers grow in the order the creates were stored.
+		if i > 0 && b.Version <= created[i-1].Version {
+			t.Errorf("seq %d was stored at version %d, before seq %d at version %d",
+				b.Seq, b.Version, created[i-1].Seq, created[i-1].Version)
+		}
+	}
+}
+
+func TestSeqSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.json")
+	s := New(path, 1)
+	var last models.Book
+	for i := 0; i < 3; i++ {
+		var err error
+		if last, err = s.Create(models.Book{Title: "Book", Author: "Author"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Purging the newest book must not free its number for reuse.
+	if err := s.Purge(last.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := New(path, 1)
+	if loaded, err := restarted.Load(config.InvalidRecordsKeep); err != nil || !loaded {
+		t.Fatalf("Load() = %v, %v; want true, nil", loaded, err)
+	}
+	b, err := restarted.Create(models.Book{Title: "Book", Author: "Author"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Seq != 4 {
+		t.Errorf("first book after restart got seq %d, want 4", b.Seq)
+	}
+}
+
+func TestPurgeTrashEveryRemovesOnlyOldBooks(t *testing.T) {
+	const retention = 200 * time.Millisecond
+	s := New("", 1)
+	old, err := s.Create(models.Book{Title: "Old", Author: "Author"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := s.Create(models.Book{Title: "Recent", Author: "Author"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(old.ID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(retention + 50*time.Millisecond)
+	if err := s.Delete(recent.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.PurgeTrashEvery(ctx, retention, 10*time.Millisecond)
+
+	inTrash := func(id string) bool {
+		for _, b := range s.Trash() {
+			if b.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	for deadline := time.Now().Add(time.Second); inTrash(old.ID); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("book deleted before the retention was not purged")
+		}
+	}
+	if !inTrash(recent.ID) {
+		t.Error("book deleted within the retention was purged")
+	}
+}
+
+func TestSeedFillsOnlyAnEmptyStore(t *testing.T) {
+	seeds := []models.Book{
+		{ID: "seed-1", Title: "Clean Code", Author: "Robert C. Martin"},
+		{ID: "seed-2", Title: "Refactoring", Author: "Martin Fowler"},
+	}
+
+	s := New("", 1)
+	if seeded, err := s.Seed(seeds); err != nil || !seeded {
+		t.Fatalf("empty store: Seed() = %v, %v; want true, nil", seeded, err)
+	}
+	if _, ok := s.Get("seed-2"); !ok || s.Len() != 2 {
+		t.Errorf("empty store: %d books after seeding, want both seeds under their IDs", s.Len())
+	}
+	if seeded, err := s.Seed(seeds); err != nil || seeded {
+		t.Errorf("seeded store: Seed() = %v, %v; want false, nil", seeded, err)
+	}
+
+	active := New("", 1)
+	if _, err := active.Create(models.Book{Title: "Mine", Author: "Me"}); err != nil {
+		t.Fatal(err)
+	}
+	trashed := New("", 1)
+	b, err := trashed.Create(models.Book{Title: "Mine", Author: "Me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trashed.Delete(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	quarantined := New(filepath.Join(t.TempDir(), "books.json"), 1)
+	raw, err := json.Marshal(storeFile{Version: 2, LastSeq: 1, Books: []models.BookFields{
+		{ID: "invalid", Title: "Ancient", Author: "Unknown", Year: 500, Seq: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(quarantined.path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := quarantined.Load(config.InvalidRecordsQuarantine); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]*Store{"active": active, "trashed": trashed, "quarantined": quarantined} {
+		if seeded, err := s.Seed(seeds); err != nil || seeded {
+			t.Errorf("store with a %s book: Seed() = %v, %v; want false, nil", name, seeded, err)
+		}
+		if _, ok := s.Get("seed-1"); ok {
+			t.Errorf("store with a %s book: seed was stored", name)
+		}
+	}
+}